@@ -9,16 +9,21 @@ import (
 type ContentTypeOrder string
 
 const (
-	MimeTypeCar                = "application/vnd.ipld.car"            // One of two acceptable MIME types
-	MimeTypeRaw                = "application/vnd.ipld.raw"            // One of two acceptable MIME types
+	MimeTypeCar                = "application/vnd.ipld.car"            // One of the acceptable MIME types
+	MimeTypeRaw                = "application/vnd.ipld.raw"            // One of the acceptable MIME types
+	MimeTypeIpnsRecord         = "application/vnd.ipfs.ipns-record"    // One of the acceptable MIME types, for signed IPNS records
 	MimeTypeCarVersion         = "1"                                   // We only accept version 1 of the CAR MIME type
-	FormatParameterCar         = "car"                                 // One of two acceptable format parameter values
-	FormatParameterRaw         = "raw"                                 // One of two acceptable format parameter values
+	FormatParameterCar         = "car"                                 // One of the acceptable format parameter values
+	FormatParameterRaw         = "raw"                                 // One of the acceptable format parameter values
+	FormatParameterIpnsRecord  = "ipns-record"                         // One of the acceptable format parameter values, for signed IPNS records
 	FilenameExtCar             = ".car"                                // Valid filename extension for CAR responses
 	FilenameExtRaw             = ".bin"                                // Valid filename extension for raw block responses
+	FilenameExtIpnsRecord      = ".ipns-record"                        // Valid filename extension for IPNS record responses
 	ResponseCacheControlHeader = "public, max-age=29030400, immutable" // Magic cache control values
 	DefaultIncludeDupes        = true                                  // The default value for an unspecified "dups" parameter.
 	DefaultOrder               = ContentTypeOrderDfs                   // The default value for an unspecified "order" parameter.
+	DefaultMaxSelectorSize     = 4 << 10                               // The default maximum length of a "selector" parameter.
+	DefaultMaxSelectorDepth    = 1 << 10                               // The default maximum depth limit of a recursive explore within a "selector" parameter.
 
 	ContentTypeOrderDfs ContentTypeOrder = "dfs"
 	ContentTypeOrderUnk ContentTypeOrder = "unk"
@@ -64,6 +69,10 @@ func (ct ContentType) IsRaw() bool {
 	return ct.MimeType == MimeTypeRaw
 }
 
+func (ct ContentType) IsIpnsRecord() bool {
+	return ct.MimeType == MimeTypeIpnsRecord
+}
+
 func (ct ContentType) IsCar() bool {
 	return ct.MimeType == MimeTypeCar || ct.MimeType == "application/*" || ct.MimeType == "*/*"
 }
@@ -114,6 +123,8 @@ func (ct ContentType) ContentLocation(requestURL string) string {
 	formatParam := FormatParameterCar
 	if ct.IsRaw() {
 		formatParam = FormatParameterRaw
+	} else if ct.IsIpnsRecord() {
+		formatParam = FormatParameterIpnsRecord
 	}
 
 	// Build Content-Location URL with format parameter
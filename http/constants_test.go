@@ -42,6 +42,12 @@ func TestContentLocation(t *testing.T) {
 			requestURL:  "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi",
 			expected:    "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi?format=raw",
 		},
+		{
+			name:        "ipns-record without format param",
+			contentType: trustlesshttp.ContentType{MimeType: trustlesshttp.MimeTypeIpnsRecord},
+			requestURL:  "/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8",
+			expected:    "/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8?format=ipns-record",
+		},
 		{
 			name:        "CAR with existing query params",
 			contentType: trustlesshttp.DefaultContentType(),
@@ -0,0 +1,212 @@
+package trustlesshttp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+)
+
+// ParseDownload validates the download query parameter, returning an error if
+// it is set to anything other than "true" or "false".
+//
+// Trustless Gateway responses are always presented as a download, see
+// ContentDisposition, so the parameter is validated and otherwise ignored.
+// See https://specs.ipfs.tech/http-gateways/path-gateway/#download-request-query-parameter
+func ParseDownload(req *http.Request) error {
+	if req.URL.Query().Has("download") {
+		switch req.URL.Query().Get("download") {
+		case "true", "false":
+		default:
+			return errors.New("invalid download parameter")
+		}
+	}
+	return nil
+}
+
+// ContentDisposition returns an "attachment" Content-Disposition header value
+// for a Trustless Gateway response, suitable for use with the result of
+// ParseFilename. The disposition is always "attachment", regardless of any
+// download parameter, as CAR, raw and IPNS record bodies are not displayed
+// inline.
+//
+// If filename is empty, a default of "<root>.car", "<root>.bin" or
+// "<root>.ipns-record" is used, depending on whether the ContentType is CAR,
+// raw or an IPNS record (where root is the CID form of the name).
+//
+// Filenames are encoded according to RFC 6266; names that are not plain,
+// printable ASCII are provided both as an ASCII approximation in the filename
+// parameter and in full, using RFC 5987 encoding, in the filename* parameter.
+func ContentDisposition(root cid.Cid, filename string, ct ContentType) string {
+	if filename == "" {
+		ext := FilenameExtCar
+		if ct.IsRaw() {
+			ext = FilenameExtRaw
+		} else if ct.IsIpnsRecord() {
+			ext = FilenameExtIpnsRecord
+		}
+		filename = root.String() + ext
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`attachment; filename="`)
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case r < 0x20 || r >= 0x7f:
+			ascii = false
+			sb.WriteRune('_')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteRune('"')
+	if !ascii {
+		sb.WriteString("; filename*=UTF-8''")
+		sb.WriteString(encodeRFC5987(filename))
+	}
+	return sb.String()
+}
+
+// encodeRFC5987 percent-encodes all bytes of s other than the attr-char set
+// defined in RFC 5987.
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteByte(hex[c>>4])
+		sb.WriteByte(hex[c&0xf])
+	}
+	return sb.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	switch c {
+	case '!', '#', '$', '&', '+', '-', '.', '^', '_', '`', '|', '~':
+		return true
+	}
+	return false
+}
+
+// ResponseHeaders returns the set of headers that should be included in a
+// Trustless Gateway response to the given HTTP request, where tr describes
+// the parsed request and ct is the ContentType selected for the response (the
+// first acceptable entry returned by CheckFormat).
+//
+// The following headers are set:
+//
+// * Content-Type, with the CAR parameters of ct where it is a CAR response
+//
+// * Etag, using Request.Etag with the order and duplicates of ct for CAR
+// responses, or the root CID for raw responses without a path
+//
+// * X-Ipfs-Path, the escaped path of the request URL, prefixed with
+// /ipfs/<cid> for subdomain-style and cid query parameter requests (see
+// ParseRequestPath)
+//
+// * X-Ipfs-Roots, from Request.IpfsRoots, where known, except for IPNS record
+// responses
+//
+// * Cache-Control, as ResponseCacheControlHeader, except for IPNS record
+// responses and responses to /ipns/ requests
+//
+// * Content-Location, from ContentType.ContentLocation, where the format was
+// negotiated with an Accept header
+//
+// * Content-Disposition, from ContentDisposition using any filename query
+// parameter
+//
+// * Vary: Accept
+//
+// * X-Content-Type-Options: nosniff
+//
+// * Accept-Ranges: none, for CAR responses which are streamed
+//
+// IPNS records and the content /ipns/ names resolve to are mutable, so must not
+// be cached as immutable content. For an IPNS record response the caller should
+// set a Cache-Control header based on the TTL of the record, such as from
+// RecordCacheControl. For an /ipns/ request, NameResolution#ResponseHeaders
+// sets a Cache-Control header from the TTL of the resolution.
+func ResponseHeaders(req *http.Request, tr trustlessutils.Request, ct ContentType) http.Header {
+	header := make(http.Header)
+
+	ct = ct.WithQuality(1)
+	if ct.IsCar() && ct.MimeType != MimeTypeCar {
+		// a wildcard was accepted, we respond with a CAR
+		ct = ct.WithMimeType(MimeTypeCar)
+	}
+	header.Set("Content-Type", ct.String())
+
+	if ct.IsCar() {
+		// the response has the duplicates of the selected ContentType, which may
+		// differ from those of the parsed request
+		etr := tr
+		etr.Duplicates = ct.Duplicates
+		header.Set("Etag", etr.Etag(string(ct.Order)))
+		header.Set("Accept-Ranges", "none")
+	} else if ct.IsRaw() && tr.Path == "" {
+		header.Set("Etag", `"`+tr.Root.String()+`.raw"`)
+	}
+
+	header.Set("X-Ipfs-Path", ipfsPath(req))
+	if !ct.IsIpnsRecord() {
+		if roots := tr.IpfsRoots(); roots != "" {
+			header.Set("X-Ipfs-Roots", roots)
+		}
+		if !isIpnsPath(req) {
+			header.Set("Cache-Control", ResponseCacheControlHeader)
+		}
+	}
+	if cl := ct.ContentLocation(req.URL.String()); cl != "" {
+		header.Set("Content-Location", cl)
+	}
+
+	// filename is expected to have been validated already, in which case any
+	// error here is ignored in favour of the default
+	filename, _ := ParseFilename(req, []ContentType{ct})
+	header.Set("Content-Disposition", ContentDisposition(tr.Root, filename, ct))
+
+	header.Set("Vary", "Accept")
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	return header
+}
+
+// isIpnsPath returns true if the request is for a mutable /ipns/ path.
+func isIpnsPath(req *http.Request) bool {
+	if _, _, ok := contentRoot(req); ok {
+		// the URL path is the path within the DAG
+		return false
+	}
+	return strings.HasPrefix(req.URL.Path, "/ipns/")
+}
+
+// ipfsPath returns the escaped /ipfs/<cid>[/<path>] content path of the
+// request, restoring the CID from the Host for subdomain-style requests, or
+// from the query for requests with a cid query parameter.
+func ipfsPath(req *http.Request) string {
+	escaped := req.URL.EscapedPath()
+	_, label, ok := contentRoot(req)
+	if !ok {
+		return escaped
+	}
+	if escaped == "/" {
+		escaped = ""
+	}
+	return "/ipfs/" + label + escaped
+}
@@ -0,0 +1,309 @@
+package trustlesshttp_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDownload(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{"no query", "", ""},
+		{"true", "download=true", ""},
+		{"false", "download=false", ""},
+		{"bork", "download=bork", "invalid download parameter"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &http.Request{}
+			req.URL = &url.URL{RawQuery: tc.query}
+			err := trustlesshttp.ParseDownload(req)
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	car := trustlesshttp.DefaultContentType()
+	raw := trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw)
+
+	for _, tc := range []struct {
+		name        string
+		filename    string
+		contentType trustlesshttp.ContentType
+		expected    string
+	}{
+		{
+			name:        "default car",
+			contentType: car,
+			expected:    `attachment; filename="` + testCidV1.String() + `.car"`,
+		},
+		{
+			name:        "default raw",
+			contentType: raw,
+			expected:    `attachment; filename="` + testCidV1.String() + `.bin"`,
+		},
+		{
+			name:        "default ipns-record",
+			contentType: trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeIpnsRecord),
+			expected:    `attachment; filename="` + testCidV1.String() + `.ipns-record"`,
+		},
+		{
+			name:        "filename",
+			filename:    "my-dag.car",
+			contentType: car,
+			expected:    `attachment; filename="my-dag.car"`,
+		},
+		{
+			name:        "quotes and spaces",
+			filename:    `my "best" \ block.bin`,
+			contentType: raw,
+			expected:    `attachment; filename="my \"best\" \\ block.bin"`,
+		},
+		{
+			name:        "non-ascii",
+			filename:    "żółw 🐢.car",
+			contentType: car,
+			expected:    `attachment; filename="___w _.car"; filename*=UTF-8''%C5%BC%C3%B3%C5%82w%20%F0%9F%90%A2.car`,
+		},
+		{
+			name:        "control characters",
+			filename:    "a\nb.car",
+			contentType: car,
+			expected:    `attachment; filename="a_b.car"; filename*=UTF-8''a%0Ab.car`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, trustlesshttp.ContentDisposition(testCidV1, tc.filename, tc.contentType))
+		})
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	car := trustlesshttp.DefaultContentType()
+	raw := trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw)
+	root := testCidV1.String()
+
+	for _, tc := range []struct {
+		name        string
+		host        string
+		url         string
+		request     trustlessutils.Request
+		contentType trustlesshttp.ContentType
+		expected    map[string]string
+	}{
+		{
+			name:        "car",
+			url:         "/ipfs/" + root + "?format=car",
+			request:     trustlessutils.Request{Root: testCidV1, Scope: trustlessutils.DagScopeAll, Duplicates: true},
+			contentType: car,
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.car;version=1;order=dfs;dups=y",
+				"Etag":                   trustlessutils.Request{Root: testCidV1, Scope: trustlessutils.DagScopeAll, Duplicates: true}.Etag("dfs"),
+				"X-Ipfs-Path":            "/ipfs/" + root,
+				"X-Ipfs-Roots":           root,
+				"Cache-Control":          trustlesshttp.ResponseCacheControlHeader,
+				"Content-Disposition":    `attachment; filename="` + root + `.car"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+				"Accept-Ranges":          "none",
+			},
+		},
+		{
+			name:        "car negotiated with Accept",
+			url:         "/ipfs/" + root + "/some/path?dag-scope=entity",
+			request:     trustlessutils.Request{Root: testCidV1, Path: "some/path", Scope: trustlessutils.DagScopeEntity},
+			contentType: car.WithDuplicates(false).WithOrder(trustlesshttp.ContentTypeOrderUnk).WithQuality(0.5),
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.car;version=1;order=unk;dups=n",
+				"Etag":                   trustlessutils.Request{Root: testCidV1, Path: "some/path", Scope: trustlessutils.DagScopeEntity}.Etag("unk"),
+				"X-Ipfs-Path":            "/ipfs/" + root + "/some/path",
+				"Cache-Control":          trustlesshttp.ResponseCacheControlHeader,
+				"Content-Location":       "/ipfs/" + root + "/some/path?dag-scope=entity&format=car",
+				"Content-Disposition":    `attachment; filename="` + root + `.car"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+				"Accept-Ranges":          "none",
+			},
+		},
+		{
+			name:        "car duplicates from content type",
+			url:         "/ipfs/" + root + "?format=car",
+			request:     trustlessutils.Request{Root: testCidV1, Scope: trustlessutils.DagScopeAll},
+			contentType: car,
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.car;version=1;order=dfs;dups=y",
+				"Etag":                   trustlessutils.Request{Root: testCidV1, Scope: trustlessutils.DagScopeAll, Duplicates: true}.Etag("dfs"),
+				"X-Ipfs-Path":            "/ipfs/" + root,
+				"X-Ipfs-Roots":           root,
+				"Cache-Control":          trustlesshttp.ResponseCacheControlHeader,
+				"Content-Disposition":    `attachment; filename="` + root + `.car"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+				"Accept-Ranges":          "none",
+			},
+		},
+		{
+			name:        "car wildcard",
+			url:         "/ipfs/" + root + "?filename=my%20dag.car&download=true",
+			request:     trustlessutils.Request{Root: testCidV1, Scope: trustlessutils.DagScopeAll, Duplicates: true},
+			contentType: car.WithMimeType("*/*"),
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.car;version=1;order=dfs;dups=y",
+				"Etag":                   trustlessutils.Request{Root: testCidV1, Scope: trustlessutils.DagScopeAll, Duplicates: true}.Etag("dfs"),
+				"X-Ipfs-Path":            "/ipfs/" + root,
+				"X-Ipfs-Roots":           root,
+				"Cache-Control":          trustlesshttp.ResponseCacheControlHeader,
+				"Content-Location":       "/ipfs/" + root + "?filename=my%20dag.car&download=true&format=car",
+				"Content-Disposition":    `attachment; filename="my dag.car"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+				"Accept-Ranges":          "none",
+			},
+		},
+		{
+			name:        "raw",
+			url:         "/ipfs/" + root + "?format=raw&filename=block.bin",
+			request:     trustlessutils.Request{Root: testCidV1},
+			contentType: raw,
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.raw",
+				"Etag":                   `"` + root + `.raw"`,
+				"X-Ipfs-Path":            "/ipfs/" + root,
+				"X-Ipfs-Roots":           root,
+				"Cache-Control":          trustlesshttp.ResponseCacheControlHeader,
+				"Content-Disposition":    `attachment; filename="block.bin"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+			},
+		},
+		{
+			name:        "ipns-record",
+			url:         "/ipns/" + root + "?format=ipns-record",
+			request:     trustlessutils.Request{Root: testCidV1},
+			contentType: trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeIpnsRecord),
+			expected: map[string]string{
+				"Content-Type":           trustlesshttp.MimeTypeIpnsRecord,
+				"X-Ipfs-Path":            "/ipns/" + root,
+				"Content-Disposition":    `attachment; filename="` + root + `.ipns-record"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+			},
+		},
+		{
+			name:        "ipns path",
+			url:         "/ipns/example.com/some/path?format=car",
+			request:     trustlessutils.Request{Root: testCidV1, Path: "some/path", Scope: trustlessutils.DagScopeAll, Duplicates: true},
+			contentType: car,
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.car;version=1;order=dfs;dups=y",
+				"Etag":                   trustlessutils.Request{Root: testCidV1, Path: "some/path", Scope: trustlessutils.DagScopeAll, Duplicates: true}.Etag("dfs"),
+				"X-Ipfs-Path":            "/ipns/example.com/some/path",
+				"Content-Disposition":    `attachment; filename="` + root + `.car"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+				"Accept-Ranges":          "none",
+			},
+		},
+		{
+			name:        "ipns path raw",
+			url:         "/ipns/example.com?format=raw",
+			request:     trustlessutils.Request{Root: testCidV1},
+			contentType: raw,
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.raw",
+				"Etag":                   `"` + root + `.raw"`,
+				"X-Ipfs-Path":            "/ipns/example.com",
+				"X-Ipfs-Roots":           root,
+				"Content-Disposition":    `attachment; filename="` + root + `.bin"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+			},
+		},
+		{
+			name:        "subdomain",
+			host:        root + ".ipfs.example.com",
+			url:         "/some/path?format=car",
+			request:     trustlessutils.Request{Root: testCidV1, Path: "some/path", Scope: trustlessutils.DagScopeAll, Duplicates: true},
+			contentType: car,
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.car;version=1;order=dfs;dups=y",
+				"Etag":                   trustlessutils.Request{Root: testCidV1, Path: "some/path", Scope: trustlessutils.DagScopeAll, Duplicates: true}.Etag("dfs"),
+				"X-Ipfs-Path":            "/ipfs/" + root + "/some/path",
+				"Cache-Control":          trustlesshttp.ResponseCacheControlHeader,
+				"Content-Disposition":    `attachment; filename="` + root + `.car"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+				"Accept-Ranges":          "none",
+			},
+		},
+		{
+			name:        "subdomain root",
+			host:        root + ".ipfs.example.com",
+			url:         "/?format=raw",
+			request:     trustlessutils.Request{Root: testCidV1},
+			contentType: raw,
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.raw",
+				"Etag":                   `"` + root + `.raw"`,
+				"X-Ipfs-Path":            "/ipfs/" + root,
+				"X-Ipfs-Roots":           root,
+				"Cache-Control":          trustlesshttp.ResponseCacheControlHeader,
+				"Content-Disposition":    `attachment; filename="` + root + `.bin"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+			},
+		},
+		{
+			name:        "cid parameter",
+			url:         "/some/path?cid=" + testCidV0.String() + "&format=raw",
+			request:     trustlessutils.Request{Root: testCidV0, Path: "some/path"},
+			contentType: raw,
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.raw",
+				"X-Ipfs-Path":            "/ipfs/" + testCidV0.String() + "/some/path",
+				"Cache-Control":          trustlesshttp.ResponseCacheControlHeader,
+				"Content-Disposition":    `attachment; filename="` + testCidV0.String() + `.bin"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+			},
+		},
+		{
+			name:        "raw with path",
+			url:         "/ipfs/" + root + "/a%20b?format=raw",
+			request:     trustlessutils.Request{Root: testCidV1, Path: "a b"},
+			contentType: raw,
+			expected: map[string]string{
+				"Content-Type":           "application/vnd.ipld.raw",
+				"X-Ipfs-Path":            "/ipfs/" + root + "/a%20b",
+				"Cache-Control":          trustlesshttp.ResponseCacheControlHeader,
+				"Content-Disposition":    `attachment; filename="` + root + `.bin"`,
+				"Vary":                   "Accept",
+				"X-Content-Type-Options": "nosniff",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.url)
+			require.NoError(t, err)
+			header := trustlesshttp.ResponseHeaders(&http.Request{Host: tc.host, URL: u}, tc.request, tc.contentType)
+			actual := make(map[string]string)
+			for k := range header {
+				actual[k] = header.Get(k)
+			}
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package trustlesshttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"go.uber.org/multierr"
+)
+
+// MaxNameResolutionDepth is the maximum number of /ipns/ names that will be
+// followed when a name resolves to another /ipns/ path.
+const MaxNameResolutionDepth = 32
+
+var (
+	ErrNameNotFound   = errors.New("name not found")
+	ErrNameResolution = errors.New("failed to resolve name")
+)
+
+// NameResolver resolves mutable /ipns/ names.
+type NameResolver interface {
+	// Resolve returns the path that the name currently points to, which should
+	// be of the form /ipfs/<cid>[/<path>] or /ipns/<name>[/<path>], along with
+	// the length of time the result may be cached for. An error wrapping
+	// ErrNameNotFound should be returned if the name does not exist.
+	Resolve(ctx context.Context, name string) (string, time.Duration, error)
+}
+
+// NameResolution is the result of resolving an /ipns/ request path.
+type NameResolution struct {
+	// Request has the resolved Root and Path set, its other fields are left
+	// for the caller to fill from the remainder of the HTTP request.
+	Request trustlessutils.Request
+
+	// IpfsPath is the immutable /ipfs/<cid>[/<path>] form of the request path.
+	IpfsPath string
+
+	// TTL is the length of time the resolution may be cached for, the minimum
+	// of the TTLs of all names followed.
+	TTL time.Duration
+}
+
+// CacheControl returns a Cache-Control header value for a response to a
+// resolved /ipns/ request, to be used in place of the immutable
+// ResponseCacheControlHeader. A zero TTL results in a response that must not
+// be cached.
+func (nr NameResolution) CacheControl() string {
+	return cacheControl(nr.TTL)
+}
+
+// ResponseHeaders returns the headers for a response to the resolved /ipns/
+// request, as ResponseHeaders does for the Request, with a Cache-Control header
+// from CacheControl. The caller is expected to have filled the remaining
+// fields of the Request from the HTTP request.
+//
+// For an IPNS record response, no Cache-Control header is set, as for
+// ResponseHeaders; the TTL of the record applies rather than that of the
+// resolution.
+func (nr NameResolution) ResponseHeaders(req *http.Request, ct ContentType) http.Header {
+	header := ResponseHeaders(req, nr.Request, ct)
+	if !ct.IsIpnsRecord() {
+		header.Set("Cache-Control", nr.CacheControl())
+	}
+	return header
+}
+
+func cacheControl(ttl time.Duration) string {
+	seconds := int64(ttl / time.Second)
+	if seconds <= 0 {
+		return "no-cache"
+	}
+	return "public, max-age=" + strconv.FormatInt(seconds, 10)
+}
+
+// ParseNameUrlPath parses an incoming IPFS Gateway path of the form
+// /ipns/<name>[/<path>], resolving the name with the provided NameResolver.
+// Where a name resolves to another /ipns/ path, it is followed up to
+// MaxNameResolutionDepth times.
+//
+// ErrPathNotFound is returned if the path is not an /ipns/ path, and errors
+// from the NameResolver are wrapped with ErrNameResolution.
+func ParseNameUrlPath(ctx context.Context, urlPath string, resolver NameResolver) (NameResolution, error) {
+	path := datamodel.ParsePath(urlPath)
+	var seg datamodel.PathSegment
+	seg, path = path.Shift()
+	if seg.String() != "ipns" || path.Len() == 0 {
+		return NameResolution{}, ErrPathNotFound
+	}
+
+	var ttl time.Duration
+	for depth := 0; ; depth++ {
+		if depth >= MaxNameResolutionDepth {
+			return NameResolution{}, fmt.Errorf("%w: exceeded maximum resolution depth of %d", ErrNameResolution, MaxNameResolutionDepth)
+		}
+		var name datamodel.PathSegment
+		name, path = path.Shift()
+		resolved, nameTTL, err := resolver.Resolve(ctx, name.String())
+		if err != nil {
+			// TODO: post-1.19: fmt.Errorf("%w: %w", ErrNameResolution, err)
+			return NameResolution{}, multierr.Combine(ErrNameResolution, err)
+		}
+		if depth == 0 || nameTTL < ttl {
+			ttl = nameTTL
+		}
+		resolvedPath := datamodel.ParsePath(resolved)
+		path = resolvedPath.Join(path)
+		if seg, rest := path.Shift(); seg.String() == "ipns" && rest.Len() > 0 {
+			path = rest
+			continue
+		}
+		break
+	}
+
+	root, rest, err := ParseUrlPath(path.String())
+	if err != nil {
+		return NameResolution{}, fmt.Errorf("%w: resolved to invalid path: /%s", ErrNameResolution, path)
+	}
+	ipfsPath := "/ipfs/" + root.String()
+	if rest.Len() > 0 {
+		ipfsPath += "/" + rest.String()
+	}
+	return NameResolution{
+		Request:  trustlessutils.Request{Root: root, Path: rest.String()},
+		IpfsPath: ipfsPath,
+		TTL:      ttl,
+	}, nil
+}
+
+// InMemoryNameResolver is a NameResolver backed by an in-memory map of names,
+// suitable for tests and static deployments.
+type InMemoryNameResolver struct {
+	lk    sync.RWMutex
+	names map[string]inMemoryName
+}
+
+type inMemoryName struct {
+	path string
+	ttl  time.Duration
+}
+
+// NewInMemoryNameResolver returns a new, empty InMemoryNameResolver.
+func NewInMemoryNameResolver() *InMemoryNameResolver {
+	return &InMemoryNameResolver{names: make(map[string]inMemoryName)}
+}
+
+// Set sets the path that name resolves to and the TTL of the resolution.
+func (r *InMemoryNameResolver) Set(name string, path string, ttl time.Duration) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.names[name] = inMemoryName{path: path, ttl: ttl}
+}
+
+// Resolve implements NameResolver.
+func (r *InMemoryNameResolver) Resolve(ctx context.Context, name string) (string, time.Duration, error) {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	n, ok := r.names[name]
+	if !ok {
+		return "", 0, fmt.Errorf("%w: %s", ErrNameNotFound, name)
+	}
+	return n.path, n.ttl, nil
+}
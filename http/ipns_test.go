@@ -0,0 +1,98 @@
+package trustlesshttp_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNameUrlPath(t *testing.T) {
+	resolver := trustlesshttp.NewInMemoryNameResolver()
+	resolver.Set("example.com", "/ipfs/"+testCidV1.String(), time.Hour)
+	resolver.Set("sub.example.com", "/ipfs/"+testCidV0.String()+"/foo", 5*time.Minute)
+	resolver.Set("alias.example.com", "/ipns/example.com/bar", 10*time.Minute)
+	resolver.Set("uncached.example.com", "/ipfs/"+testCidV1.String(), 0)
+	resolver.Set("bad.example.com", "/ipfs/bork", time.Hour)
+	resolver.Set("loop.example.com", "/ipns/loop.example.com", time.Hour)
+
+	for _, tc := range []struct {
+		name          string
+		path          string
+		expectedRoot  cid.Cid
+		expectedPath  string
+		expectedIpfs  string
+		expectedTTL   time.Duration
+		expectedCache string
+		err           string
+	}{
+		{"empty", "/", cid.Undef, "", "", 0, "", "not found"},
+		{"ipfs path", "/ipfs/" + testCidV1.String(), cid.Undef, "", "", 0, "", "not found"},
+		{"just ipns", "/ipns/", cid.Undef, "", "", 0, "", "not found"},
+		{"name", "/ipns/example.com", testCidV1, "", "/ipfs/" + testCidV1.String(), time.Hour, "public, max-age=3600", ""},
+		{"name and path", "/ipns/example.com//foo/bar/", testCidV1, "foo/bar", "/ipfs/" + testCidV1.String() + "/foo/bar", time.Hour, "public, max-age=3600", ""},
+		{"name resolving to path", "/ipns/sub.example.com/bar", testCidV0, "foo/bar", "/ipfs/" + testCidV0.String() + "/foo/bar", 5 * time.Minute, "public, max-age=300", ""},
+		{"name resolving to name", "/ipns/alias.example.com/baz", testCidV1, "bar/baz", "/ipfs/" + testCidV1.String() + "/bar/baz", 10 * time.Minute, "public, max-age=600", ""},
+		{"zero ttl", "/ipns/uncached.example.com", testCidV1, "", "/ipfs/" + testCidV1.String(), 0, "no-cache", ""},
+		{"unknown name", "/ipns/nope.example.com", cid.Undef, "", "", 0, "", "failed to resolve name; name not found: nope.example.com"},
+		{"bad resolved path", "/ipns/bad.example.com", cid.Undef, "", "", 0, "", "failed to resolve name: resolved to invalid path: /ipfs/bork"},
+		{"resolution loop", "/ipns/loop.example.com", cid.Undef, "", "", 0, "", "failed to resolve name: exceeded maximum resolution depth of 32"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := trustlesshttp.ParseNameUrlPath(context.Background(), tc.path, resolver)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedRoot, res.Request.Root)
+			require.Equal(t, tc.expectedPath, res.Request.Path)
+			require.Equal(t, tc.expectedIpfs, res.IpfsPath)
+			require.Equal(t, tc.expectedTTL, res.TTL)
+			require.Equal(t, tc.expectedCache, res.CacheControl())
+		})
+	}
+
+	_, err := trustlesshttp.ParseNameUrlPath(context.Background(), "/ipns/nope.example.com", resolver)
+	require.ErrorIs(t, err, trustlesshttp.ErrNameResolution)
+	require.ErrorIs(t, err, trustlesshttp.ErrNameNotFound)
+}
+
+func TestNameResolutionResponseHeaders(t *testing.T) {
+	resolver := trustlesshttp.NewInMemoryNameResolver()
+	resolver.Set("example.com", "/ipfs/"+testCidV1.String(), time.Hour)
+	resolver.Set("uncached.example.com", "/ipfs/"+testCidV1.String(), 0)
+
+	car := trustlesshttp.DefaultContentType()
+	raw := car.WithMimeType(trustlesshttp.MimeTypeRaw)
+	record := car.WithMimeType(trustlesshttp.MimeTypeIpnsRecord)
+
+	for _, tc := range []struct {
+		name          string
+		path          string
+		contentType   trustlesshttp.ContentType
+		expectedCache string
+	}{
+		{"car", "/ipns/example.com", car, "public, max-age=3600"},
+		{"raw", "/ipns/example.com", raw, "public, max-age=3600"},
+		{"zero ttl", "/ipns/uncached.example.com", car, "no-cache"},
+		{"ipns record", "/ipns/example.com", record, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := trustlesshttp.ParseNameUrlPath(context.Background(), tc.path, resolver)
+			require.NoError(t, err)
+			res.Request.Scope = trustlessutils.DagScopeAll
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			header := res.ResponseHeaders(req, tc.contentType)
+			require.Equal(t, tc.expectedCache, header.Get("Cache-Control"))
+			require.Equal(t, tc.path, header.Get("X-Ipfs-Path"))
+		})
+	}
+}
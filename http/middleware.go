@@ -0,0 +1,321 @@
+package trustlesshttp
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+)
+
+// Termination describes why a request handled by LoggingHandler ended.
+type Termination string
+
+const (
+	TerminationComplete Termination = "complete" // the response was sent without error
+	TerminationRejected Termination = "rejected" // the handler responded with an error status
+	TerminationError    Termination = "error"    // the handler reported an error with RecordTraversal
+	TerminationCanceled Termination = "canceled" // the request context ended before the handler completed, typically because the client went away
+)
+
+// RequestLog holds the structured fields recorded by LoggingHandler for a
+// single request.
+type RequestLog struct {
+	Start    time.Time
+	Duration time.Duration
+
+	// Root, Path, Scope and Bytes are parsed from the request URL, they are
+	// left unset where the request could not be parsed.
+	Root  cid.Cid
+	Path  string
+	Scope trustlessutils.DagScope
+	Bytes *trustlessutils.ByteRange
+
+	// ContentType is parsed from the Content-Type header of the response, it
+	// is left unset where the handler did not set a Trustless Content-Type.
+	ContentType ContentType
+
+	Status       int   // the response status, 200 if the handler did not set one
+	BytesWritten int64 // the number of response body bytes written
+
+	// BlocksOut and BytesOut are the counts passed to RecordTraversal, if
+	// called.
+	BlocksOut uint64
+	BytesOut  uint64
+
+	// TimeToFirstBlock is the time from the start of the request to the first
+	// call to RecordBlock, or to the first write of the response body if
+	// RecordBlock was not called. It is zero if neither occurred.
+	TimeToFirstBlock time.Duration
+
+	Termination Termination
+	Err         error // the error passed to RecordTraversal, if any
+}
+
+// RequestLogSink receives a RequestLog for every request handled by a
+// LoggingHandler, once the handler has returned.
+type RequestLogSink interface {
+	LogRequest(ctx context.Context, log RequestLog)
+}
+
+// RequestLogSinkFunc adapts a function to a RequestLogSink.
+type RequestLogSinkFunc func(ctx context.Context, log RequestLog)
+
+// LogRequest implements RequestLogSink.
+func (f RequestLogSinkFunc) LogRequest(ctx context.Context, log RequestLog) {
+	f(ctx, log)
+}
+
+// SlogRequestLogSink is a RequestLogSink that writes each RequestLog to a
+// structured logger at Info level, or Warn level where the request did not
+// complete.
+type SlogRequestLogSink struct {
+	Logger *slog.Logger // the logger to write to; if nil, slog.Default() is used
+}
+
+// LogRequest implements RequestLogSink.
+func (s SlogRequestLogSink) LogRequest(ctx context.Context, log RequestLog) {
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	level := slog.LevelInfo
+	if log.Termination != TerminationComplete {
+		level = slog.LevelWarn
+	}
+	attrs := []slog.Attr{
+		slog.String("root", log.Root.String()),
+		slog.String("path", log.Path),
+		slog.String("scope", string(log.Scope)),
+	}
+	if log.Bytes != nil {
+		attrs = append(attrs, slog.String("entity-bytes", log.Bytes.String()))
+	}
+	if log.ContentType.MimeType != "" {
+		attrs = append(attrs, slog.String("content-type", log.ContentType.String()))
+	}
+	attrs = append(attrs,
+		slog.Int("status", log.Status),
+		slog.Int64("bytes-written", log.BytesWritten),
+		slog.Uint64("blocks-out", log.BlocksOut),
+		slog.Uint64("bytes-out", log.BytesOut),
+		slog.Duration("time-to-first-block", log.TimeToFirstBlock),
+		slog.Duration("duration", log.Duration),
+		slog.String("termination", string(log.Termination)),
+	)
+	if log.Err != nil {
+		attrs = append(attrs, slog.String("error", log.Err.Error()))
+	}
+	logger.LogAttrs(ctx, level, "trustless gateway request", attrs...)
+}
+
+// MemoryRequestLogSink is a RequestLogSink that keeps every RequestLog in
+// memory, suitable for tests.
+type MemoryRequestLogSink struct {
+	lk   sync.Mutex
+	logs []RequestLog
+}
+
+// LogRequest implements RequestLogSink.
+func (s *MemoryRequestLogSink) LogRequest(_ context.Context, log RequestLog) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.logs = append(s.logs, log)
+}
+
+// Logs returns a copy of the RequestLogs received so far, in the order they
+// were received.
+func (s *MemoryRequestLogSink) Logs() []RequestLog {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return append([]RequestLog(nil), s.logs...)
+}
+
+type requestLogKey struct{}
+
+// Clock provides the current time, it may be passed to LoggingHandler to make
+// the durations in a RequestLog deterministic in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// requestRecorder accumulates the fields of a RequestLog that are reported by
+// the wrapped handler, it is shared via the request context.
+type requestRecorder struct {
+	lk         sync.Mutex
+	clock      Clock
+	start      time.Time
+	firstBlock time.Time
+	firstWrite time.Time
+	blocksOut  uint64
+	bytesOut   uint64
+	err        error
+}
+
+func (rr *requestRecorder) markFirst(t *time.Time) {
+	rr.lk.Lock()
+	defer rr.lk.Unlock()
+	if t.IsZero() {
+		*t = rr.clock.Now()
+	}
+}
+
+func recorderFrom(ctx context.Context) *requestRecorder {
+	rr, _ := ctx.Value(requestLogKey{}).(*requestRecorder)
+	return rr
+}
+
+// RecordTraversal reports the number of blocks and bytes of block data sent by
+// the traversal performed by a handler wrapped with LoggingHandler, such as
+// the BlocksOut and BytesOut of a traversal.TraversalResult, along with any
+// error that ended it. It has no effect if ctx is not the context of a request
+// handled by LoggingHandler.
+func RecordTraversal(ctx context.Context, blocksOut uint64, bytesOut uint64, err error) {
+	rr := recorderFrom(ctx)
+	if rr == nil {
+		return
+	}
+	rr.lk.Lock()
+	defer rr.lk.Unlock()
+	rr.blocksOut = blocksOut
+	rr.bytesOut = bytesOut
+	rr.err = err
+}
+
+// RecordBlock reports that a block has been sent by a handler wrapped with
+// LoggingHandler; only the first call for a request is used, to measure the
+// time to first block. It has no effect if ctx is not the context of a request
+// handled by LoggingHandler.
+func RecordBlock(ctx context.Context) {
+	if rr := recorderFrom(ctx); rr != nil {
+		rr.markFirst(&rr.firstBlock)
+	}
+}
+
+// OnBlockRecorder returns a function to be called with the CID and size of
+// each block sent, which calls RecordBlock with ctx. It can be called from the
+// traversal.Config OnBlock callback, which reports blocks as they are loaded
+// by Traverse.
+func OnBlockRecorder(ctx context.Context) func(cid.Cid, uint64) {
+	return func(cid.Cid, uint64) { RecordBlock(ctx) }
+}
+
+// LoggingHandler wraps a Trustless Gateway handler and reports a RequestLog
+// for every request to sink. If sink is nil, a SlogRequestLogSink using
+// slog.Default() is used. The times and durations in the RequestLog are
+// measured with clock, or the system clock if clock is nil.
+//
+// The request parameters are parsed from the request and the negotiated
+// ContentType from the response headers, so the wrapped handler need not
+// report them. The handler should report the result of its traversal with
+// RecordTraversal, and may report blocks as they are sent with RecordBlock,
+// or OnBlockRecorder, to measure the time to first block more precisely than
+// the time to first write.
+func LoggingHandler(next http.Handler, sink RequestLogSink, clock Clock) http.Handler {
+	if sink == nil {
+		sink = SlogRequestLogSink{}
+	}
+	if clock == nil {
+		clock = systemClock{}
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		rr := &requestRecorder{clock: clock, start: clock.Now()}
+		ctx := context.WithValue(req.Context(), requestLogKey{}, rr)
+		lw := &loggingResponseWriter{ResponseWriter: res, recorder: rr}
+
+		next.ServeHTTP(lw, req.WithContext(ctx))
+
+		log := RequestLog{
+			Start:        rr.start,
+			Duration:     clock.Now().Sub(rr.start),
+			Status:       lw.status,
+			BytesWritten: lw.written,
+		}
+		if log.Status == 0 {
+			log.Status = http.StatusOK
+		}
+		if root, path, err := ParseRequestPath(req); err == nil {
+			log.Root = root
+			log.Path = path.String()
+		}
+		if scope, err := ParseScope(req); err == nil {
+			log.Scope = scope
+		}
+		if br, err := ParseByteRange(req); err == nil {
+			log.Bytes = br
+		}
+		if ct, ok := ParseContentType(res.Header().Get("Content-Type")); ok {
+			log.ContentType = ct
+		}
+
+		rr.lk.Lock()
+		if !rr.firstBlock.IsZero() {
+			log.TimeToFirstBlock = rr.firstBlock.Sub(rr.start)
+		} else if !rr.firstWrite.IsZero() {
+			log.TimeToFirstBlock = rr.firstWrite.Sub(rr.start)
+		}
+		log.BlocksOut = rr.blocksOut
+		log.BytesOut = rr.bytesOut
+		log.Err = rr.err
+		rr.lk.Unlock()
+
+		switch {
+		case ctx.Err() != nil:
+			log.Termination = TerminationCanceled
+		case log.Err != nil:
+			log.Termination = TerminationError
+		case log.Status >= http.StatusBadRequest:
+			log.Termination = TerminationRejected
+		default:
+			log.Termination = TerminationComplete
+		}
+
+		sink.LogRequest(req.Context(), log)
+	})
+}
+
+// loggingResponseWriter records the status and body size of a response. It
+// implements http.Flusher, as Trustless responses are streamed, and Unwrap for
+// use with http.ResponseController.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	recorder *requestRecorder
+	status   int
+	written  int64
+}
+
+func (lw *loggingResponseWriter) WriteHeader(status int) {
+	if lw.status == 0 {
+		lw.status = status
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	if len(b) > 0 {
+		lw.recorder.markFirst(&lw.recorder.firstWrite)
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.written += int64(n)
+	return n, err
+}
+
+func (lw *loggingResponseWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
@@ -0,0 +1,241 @@
+package trustlesshttp_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggingHandler(t *testing.T) {
+	errTraversal := errors.New("traversal failed")
+	car := trustlesshttp.DefaultContentType().WithDuplicates(false)
+
+	for _, tc := range []struct {
+		name        string
+		url         string
+		cancel      bool
+		handler     func(res http.ResponseWriter, req *http.Request)
+		expectLog   trustlesshttp.RequestLog
+		expectFirst bool
+	}{
+		{
+			name: "complete",
+			url:  "/ipfs/" + testCidV1.String() + "/foo/bar?dag-scope=entity&entity-bytes=0:99",
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				res.Header().Set("Content-Type", car.String())
+				res.WriteHeader(http.StatusOK)
+				trustlesshttp.RecordBlock(req.Context())
+				_, _ = res.Write([]byte("car data"))
+				trustlesshttp.RecordTraversal(req.Context(), 3, 300, nil)
+			},
+			expectLog: trustlesshttp.RequestLog{
+				Root:         testCidV1,
+				Path:         "foo/bar",
+				Scope:        trustlessutils.DagScopeEntity,
+				Bytes:        &trustlessutils.ByteRange{From: 0, To: ptr(int64(99))},
+				ContentType:  car,
+				Status:       http.StatusOK,
+				BytesWritten: 8,
+				BlocksOut:    3,
+				BytesOut:     300,
+				Termination:  trustlesshttp.TerminationComplete,
+			},
+			expectFirst: true,
+		},
+		{
+			name: "implicit status and first write",
+			url:  "/ipfs/" + testCidV1.String(),
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				res.Header().Set("Content-Type", trustlesshttp.MimeTypeRaw)
+				_, _ = res.Write([]byte("raw"))
+			},
+			expectLog: trustlesshttp.RequestLog{
+				Root:         testCidV1,
+				Scope:        trustlessutils.DagScopeAll,
+				ContentType:  trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw),
+				Status:       http.StatusOK,
+				BytesWritten: 3,
+				Termination:  trustlesshttp.TerminationComplete,
+			},
+			expectFirst: true,
+		},
+		{
+			name: "rejected",
+			url:  "/ipfs/" + testCidV1.String() + "?dag-scope=bork",
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				http.Error(res, "invalid dag-scope parameter", http.StatusBadRequest)
+			},
+			expectLog: trustlesshttp.RequestLog{
+				Root:         testCidV1,
+				Status:       http.StatusBadRequest,
+				BytesWritten: 28,
+				Termination:  trustlesshttp.TerminationRejected,
+			},
+			expectFirst: true,
+		},
+		{
+			name: "unparseable",
+			url:  "/bork",
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				res.WriteHeader(http.StatusNotFound)
+			},
+			expectLog: trustlesshttp.RequestLog{
+				Scope:       trustlessutils.DagScopeAll,
+				Status:      http.StatusNotFound,
+				Termination: trustlesshttp.TerminationRejected,
+			},
+		},
+		{
+			name: "traversal error",
+			url:  "/ipfs/" + testCidV1.String(),
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				res.Header().Set("Content-Type", car.String())
+				trustlesshttp.RecordTraversal(req.Context(), 1, 100, errTraversal)
+			},
+			expectLog: trustlesshttp.RequestLog{
+				Root:        testCidV1,
+				Scope:       trustlessutils.DagScopeAll,
+				ContentType: car,
+				Status:      http.StatusOK,
+				BlocksOut:   1,
+				BytesOut:    100,
+				Termination: trustlesshttp.TerminationError,
+				Err:         errTraversal,
+			},
+		},
+		{
+			name:   "canceled",
+			url:    "/ipfs/" + testCidV1.String(),
+			cancel: true,
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				trustlesshttp.RecordTraversal(req.Context(), 0, 0, req.Context().Err())
+			},
+			expectLog: trustlesshttp.RequestLog{
+				Root:        testCidV1,
+				Scope:       trustlessutils.DagScopeAll,
+				Status:      http.StatusOK,
+				Termination: trustlesshttp.TerminationCanceled,
+				Err:         context.Canceled,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &trustlesshttp.MemoryRequestLogSink{}
+			handler := trustlesshttp.LoggingHandler(http.HandlerFunc(tc.handler), sink, nil)
+
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.cancel {
+				ctx, cancel := context.WithCancel(req.Context())
+				cancel()
+				req = req.WithContext(ctx)
+			}
+			before := time.Now()
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			logs := sink.Logs()
+			require.Len(t, logs, 1)
+			log := logs[0]
+			require.False(t, log.Start.Before(before))
+			require.GreaterOrEqual(t, log.Duration, time.Duration(0))
+			if tc.expectFirst {
+				require.GreaterOrEqual(t, log.TimeToFirstBlock, time.Duration(0))
+				require.LessOrEqual(t, log.TimeToFirstBlock, log.Duration)
+			} else {
+				require.Zero(t, log.TimeToFirstBlock)
+			}
+			log.Start = time.Time{}
+			log.Duration = 0
+			log.TimeToFirstBlock = 0
+			require.Equal(t, tc.expectLog, log)
+		})
+	}
+}
+
+func TestLoggingHandlerFirstBlock(t *testing.T) {
+	// RecordBlock takes precedence over an earlier write, such as a CAR header
+	sink := &trustlesshttp.MemoryRequestLogSink{}
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	handler := trustlesshttp.LoggingHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		clock.advance(time.Millisecond)
+		_, _ = res.Write([]byte("car header"))
+		res.(http.Flusher).Flush()
+		clock.advance(10 * time.Millisecond)
+		trustlesshttp.OnBlockRecorder(req.Context())(testCidV1, 5)
+		clock.advance(time.Millisecond)
+		_, _ = res.Write([]byte("block"))
+		clock.advance(time.Millisecond)
+	}), sink, clock)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ipfs/"+testCidV1.String(), nil))
+	require.True(t, rec.Flushed)
+
+	logs := sink.Logs()
+	require.Len(t, logs, 1)
+	require.Equal(t, time.Unix(1000, 0), logs[0].Start)
+	require.Equal(t, 11*time.Millisecond, logs[0].TimeToFirstBlock)
+	require.Equal(t, 13*time.Millisecond, logs[0].Duration)
+	require.Equal(t, int64(15), logs[0].BytesWritten)
+
+	// without RecordBlock, the first write is used
+	handler = trustlesshttp.LoggingHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		clock.advance(2 * time.Millisecond)
+		_, _ = res.Write([]byte("car header"))
+		clock.advance(time.Millisecond)
+	}), sink, clock)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ipfs/"+testCidV1.String(), nil))
+	logs = sink.Logs()
+	require.Len(t, logs, 2)
+	require.Equal(t, 2*time.Millisecond, logs[1].TimeToFirstBlock)
+	require.Equal(t, 3*time.Millisecond, logs[1].Duration)
+
+	// recording outside of a LoggingHandler is a no-op
+	trustlesshttp.RecordBlock(context.Background())
+	trustlesshttp.RecordTraversal(context.Background(), 0, 0, nil)
+}
+
+// manualClock is a trustlesshttp.Clock that only moves when advanced.
+type manualClock struct {
+	now time.Time
+}
+
+func (mc *manualClock) Now() time.Time { return mc.now }
+
+func (mc *manualClock) advance(d time.Duration) { mc.now = mc.now.Add(d) }
+
+func TestSlogRequestLogSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := trustlesshttp.SlogRequestLogSink{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+	handler := trustlesshttp.LoggingHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", trustlesshttp.DefaultContentType().String())
+		_, _ = res.Write([]byte("car"))
+		trustlesshttp.RecordTraversal(req.Context(), 2, 20, nil)
+	}), sink, nil)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ipfs/"+testCidV1.String()+"/a?entity-bytes=1:*", nil))
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, "INFO", entry["level"])
+	require.Equal(t, "trustless gateway request", entry["msg"])
+	require.Equal(t, testCidV1.String(), entry["root"])
+	require.Equal(t, "a", entry["path"])
+	require.Equal(t, "all", entry["scope"])
+	require.Equal(t, "1:*", entry["entity-bytes"])
+	require.Equal(t, trustlesshttp.DefaultContentType().String(), entry["content-type"])
+	require.EqualValues(t, 200, entry["status"])
+	require.EqualValues(t, 3, entry["bytes-written"])
+	require.EqualValues(t, 2, entry["blocks-out"])
+	require.EqualValues(t, 20, entry["bytes-out"])
+	require.Equal(t, "complete", entry["termination"])
+	require.NotContains(t, entry, "error")
+}
@@ -3,8 +3,11 @@ package trustlesshttp
 import (
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -14,6 +17,49 @@ import (
 	trustlessutils "github.com/ipld/go-trustless-utils"
 )
 
+// QueryParameters lists the query parameters understood by this package, as
+// checked by CheckQuery.
+var QueryParameters = []string{
+	"format",
+	"dag-scope",
+	"entity-bytes",
+	"car-version",
+	"car-order",
+	"car-dups",
+	"filename",
+	"download",
+	"pathing",
+	"selector",
+	"cid",
+}
+
+// CheckQuery performs strict validation of the query string of a request and
+// should be called before the other Parse functions where strict parsing is
+// desired. The Parse functions use the first value of a repeated parameter,
+// so a query such as "?dag-scope=entity&dag-scope=all" may be interpreted
+// differently by different implementations, or by a cache in front of them,
+// which is a cache-poisoning risk for a protocol where responses are cached
+// by URL.
+//
+// An error is returned if the query string is malformed, if any parameter is
+// repeated, or if any parameter is not one of QueryParameters or
+// additionalParameters.
+func CheckQuery(req *http.Request, additionalParameters ...string) error {
+	query, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		return fmt.Errorf("invalid query string: %w", err)
+	}
+	for param, values := range query {
+		if !slices.Contains(QueryParameters, param) && !slices.Contains(additionalParameters, param) {
+			return fmt.Errorf("invalid query string; unsupported parameter: %q", param)
+		}
+		if len(values) > 1 {
+			return fmt.Errorf("invalid query string; repeated parameter: %q", param)
+		}
+	}
+	return nil
+}
+
 // ParseScope returns the dag-scope query parameter or an error if the dag-scope
 // parameter is not one of the supported values.
 func ParseScope(req *http.Request) (trustlessutils.DagScope, error) {
@@ -27,6 +73,20 @@ func ParseScope(req *http.Request) (trustlessutils.DagScope, error) {
 	return trustlessutils.DagScopeAll, nil
 }
 
+// ParsePathing returns the non-standard pathing query parameter or an error if
+// the pathing parameter is not one of the supported values. If the parameter is
+// not set, PathingUnixFS is returned.
+func ParsePathing(req *http.Request) (trustlessutils.Pathing, error) {
+	if req.URL.Query().Has("pathing") {
+		if p, err := trustlessutils.ParsePathing(req.URL.Query().Get("pathing")); err != nil {
+			return p, errors.New("invalid pathing parameter")
+		} else {
+			return p, nil
+		}
+	}
+	return trustlessutils.PathingUnixFS, nil
+}
+
 // ParseByteRange returns the entity-bytes query parameter if one is set in the
 // query string or nil if one is not set. An error is returned if an
 // entity-bytes query string is not a valid byte range.
@@ -41,9 +101,48 @@ func ParseByteRange(req *http.Request) (*trustlessutils.ByteRange, error) {
 	return nil, nil
 }
 
+// ParseSelector returns the non-standard selector query parameter, decoded
+// into an IPLD selector, if one is set in the query string, or nil if one is
+// not set. The parameter may carry either a dag-json selector or the unpadded
+// base64url encoding of a dag-cbor selector, as produced by
+// Request.UrlPath.
+//
+// This is an opt-in extension to the Trustless Gateway protocol; servers that
+// don't call ParseSelector will ignore the parameter.
+//
+// An error is returned if the parameter is longer than maxSize bytes, is not a
+// valid selector, or contains a recursive explore without a depth limit or with
+// a depth limit greater than maxRecursionDepth. A maxSize or maxRecursionDepth
+// of zero will use DefaultMaxSelectorSize or DefaultMaxSelectorDepth
+// respectively.
+func ParseSelector(req *http.Request, maxSize int, maxRecursionDepth int64) (datamodel.Node, error) {
+	if !req.URL.Query().Has("selector") {
+		return nil, nil
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxSelectorSize
+	}
+	if maxRecursionDepth == 0 {
+		maxRecursionDepth = DefaultMaxSelectorDepth
+	}
+	s := req.URL.Query().Get("selector")
+	if len(s) > maxSize {
+		return nil, fmt.Errorf("invalid selector parameter; exceeds maximum length of %d", maxSize)
+	}
+	sel, err := trustlessutils.DecodeSelector(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector parameter; %w", err)
+	}
+	if err := trustlessutils.CheckSelector(sel, maxRecursionDepth); err != nil {
+		return nil, fmt.Errorf("invalid selector parameter; %w", err)
+	}
+	return sel, nil
+}
+
 // ParseFilename returns the filename query parameter or an error if the
 // filename extension is not valid for the requested response type.
-// Accepts .car extension for CAR responses and .bin extension for raw block responses.
+// Accepts .car extension for CAR responses, .bin extension for raw block
+// responses and .ipns-record extension for IPNS record responses.
 // See https://specs.ipfs.tech/http-gateways/path-gateway/#filename-request-query-parameter
 func ParseFilename(req *http.Request, accepts []ContentType) (string, error) {
 	// check if provided filename query parameter has valid extension
@@ -71,6 +170,14 @@ func ParseFilename(req *http.Request, accepts []ContentType) (string, error) {
 				}
 			}
 			return "", fmt.Errorf("invalid filename parameter; %s extension requires raw response format", FilenameExtRaw)
+		} else if ext == FilenameExtIpnsRecord {
+			// .ipns-record is valid for IPNS record responses
+			for _, accept := range accepts {
+				if accept.IsIpnsRecord() {
+					return filename, nil
+				}
+			}
+			return "", fmt.Errorf("invalid filename parameter; %s extension requires ipns-record response format", FilenameExtIpnsRecord)
 		}
 
 		return "", fmt.Errorf("invalid filename parameter; unsupported extension: %q", ext)
@@ -87,17 +194,17 @@ func ParseFilename(req *http.Request, accepts []ContentType) (string, error) {
 // additional response formats that the IPFS Trustless Gateway spec does not
 // currently support, so we throw an error in the cases where the request is
 // requesting one the unsupported response formats. IPFS Trustless Gateway only
-// supports returning CAR, or raw block data.
+// supports returning CAR, raw block data, or signed IPNS records.
 //
 // The spec outlines that the requesting format can be provided
 // via the Accept header or the format query parameter.
 //
-// IPFS Trustless Gateway only allows the application/vnd.ipld.car
-// and application/vnd.ipld.raw Accept headers
+// IPFS Trustless Gateway only allows the application/vnd.ipld.car,
+// application/vnd.ipld.raw and application/vnd.ipfs.ipns-record Accept headers
 // https://specs.ipfs.tech/http-gateways/path-gateway/#accept-request-header
 //
-// IPFS Trustless Gateway only allows the "car" and "raw" format query
-// parameters
+// IPFS Trustless Gateway only allows the "car", "raw" and "ipns-record" format
+// query parameters
 // https://specs.ipfs.tech/http-gateways/path-gateway/#format-request-query-parameter
 //
 // Per IPIP-523: the format query parameter takes precedence over the Accept
@@ -108,7 +215,7 @@ func CheckFormat(req *http.Request) ([]ContentType, error) {
 
 	format := query.Get("format")
 	switch format {
-	case "", FormatParameterCar, FormatParameterRaw:
+	case "", FormatParameterCar, FormatParameterRaw, FormatParameterIpnsRecord:
 	default:
 		return nil, fmt.Errorf("invalid format parameter; unsupported: %q", format)
 	}
@@ -163,6 +270,8 @@ func CheckFormat(req *http.Request) ([]ContentType, error) {
 			result = []ContentType{ct}
 		case FormatParameterRaw:
 			result = []ContentType{DefaultContentType().WithMimeType(MimeTypeRaw)}
+		case FormatParameterIpnsRecord:
+			result = []ContentType{DefaultContentType().WithMimeType(MimeTypeIpnsRecord)}
 		}
 	} else if len(accepts) > 0 {
 		result = accepts
@@ -199,7 +308,8 @@ func CheckFormat(req *http.Request) ([]ContentType, error) {
 //
 // This will operate the same as ParseContentType except that it is less strict
 // with the format specifier, allowing for "application/*" and "*/*" as well as
-// the standard "application/vnd.ipld.car" and "application/vnd.ipld.raw".
+// the standard "application/vnd.ipld.car", "application/vnd.ipld.raw" and
+// "application/vnd.ipfs.ipns-record".
 func ParseAccept(acceptHeader string) []ContentType {
 	acceptTypes := strings.Split(acceptHeader, ",")
 	accepts := make([]ContentType, 0, len(acceptTypes))
@@ -221,8 +331,9 @@ func ParseAccept(acceptHeader string) []ContentType {
 // the header value was valid or not.
 //
 // This will operate similar to ParseAccept except that it strictly only
-// allows the "application/vnd.ipld.car" and "application/vnd.ipld.raw"
-// Content-Types (and it won't accept comma separated list of content types).
+// allows the "application/vnd.ipld.car", "application/vnd.ipld.raw" and
+// "application/vnd.ipfs.ipns-record" Content-Types (and it won't accept comma
+// separated list of content types).
 func ParseContentType(contentTypeHeader string) (ContentType, bool) {
 	return parseContentType(contentTypeHeader, true)
 }
@@ -230,7 +341,7 @@ func ParseContentType(contentTypeHeader string) (ContentType, bool) {
 func parseContentType(header string, strictType bool) (ContentType, bool) {
 	typeParts := strings.Split(header, ";")
 	mime := strings.TrimSpace(typeParts[0])
-	if mime == MimeTypeCar || mime == MimeTypeRaw || (!strictType && (mime == "*/*" || mime == "application/*")) {
+	if mime == MimeTypeCar || mime == MimeTypeRaw || mime == MimeTypeIpnsRecord || (!strictType && (mime == "*/*" || mime == "application/*")) {
 		contentType := DefaultContentType().WithMimeType(mime)
 		// parse additional car attributes outlined in IPIP-412
 		// https://specs.ipfs.tech/http-gateways/trustless-gateway/
@@ -317,3 +428,138 @@ func ParseUrlPath(urlPath string) (cid.Cid, datamodel.Path, error) {
 
 	return rootCid, path, nil
 }
+
+// ParseRequestPath parses the root CID and path of an incoming IPFS Trustless
+// Gateway request, accepting the path-style form handled by ParseUrlPath
+// (/ipfs/<cid>[/<path>]), the subdomain-style form, where the CID is the first
+// label of a Host of the form <cid>.ipfs.<domain> and the URL path is the path
+// within the DAG, and the form used by internal routes, where the CID is given
+// by a cid query parameter and the URL path is the path within the DAG.
+//
+// Subdomain CIDs must be CIDv1 in a case-insensitive, DNS-safe multibase
+// encoding, such as the base32 form typically used, or the base36 form used
+// where the base32 form would exceed the 63 character DNS label limit. Since
+// host names are case-insensitive, the label is lowercased before parsing.
+// CIDv0 is not accepted in a subdomain as its base58btc encoding is case
+// sensitive. Where the first label of a Host of the form <label>.ipfs.<domain>
+// is not a CIDv1, such as for a gateway served at gw.ipfs.example.com, the
+// request is treated as path-style.
+//
+// A cid query parameter may be any CID; an invalid CID results in ErrBadCid.
+// A cid query parameter on a subdomain-style request is ambiguous and is
+// rejected with ErrBadCid.
+//
+// The returned CID is the same regardless of the form or encoding used in the
+// request.
+//
+// Path segments are percent-decoded, so a path produced by
+// trustlessutils.Request#UrlPath is recovered exactly; see
+// trustlessutils.CheckPath. A path containing an encoded "/" (%2F), which
+// cannot be represented in a Request Path, or a "." or ".." segment, is
+// rejected with an error wrapping trustlessutils.ErrBadPathSegment.
+func ParseRequestPath(req *http.Request) (cid.Cid, datamodel.Path, error) {
+	// an encoded "/" would be indistinguishable from a separator once decoded
+	if strings.Contains(strings.ToLower(req.URL.EscapedPath()), "%2f") {
+		return cid.Undef, datamodel.Path{}, fmt.Errorf("%w: path segment contains an encoded \"/\"", trustlessutils.ErrBadPathSegment)
+	}
+	if err := trustlessutils.CheckPath(req.URL.Path); err != nil {
+		return cid.Undef, datamodel.Path{}, err
+	}
+	rootCid, _, isSubdomain := subdomainRoot(req)
+	if req.URL.Query().Has("cid") {
+		if isSubdomain {
+			return cid.Undef, datamodel.Path{}, fmt.Errorf("%w: cid parameter on a subdomain request", ErrBadCid)
+		}
+		var ok bool
+		if rootCid, _, ok = queryRoot(req); !ok {
+			return cid.Undef, datamodel.Path{}, ErrBadCid
+		}
+	} else if !isSubdomain {
+		return ParseUrlPath(req.URL.Path)
+	}
+	return rootCid, datamodel.ParsePath(req.URL.Path), nil
+}
+
+// contentRoot returns the root CID, and the string form it was given in, for
+// requests that carry it outside of the URL path: subdomain-style requests and
+// those with a cid query parameter.
+func contentRoot(req *http.Request) (cid.Cid, string, bool) {
+	if rootCid, label, ok := subdomainRoot(req); ok {
+		return rootCid, label, true
+	}
+	return queryRoot(req)
+}
+
+// subdomainRoot returns the root CID, and the lowercased label it was parsed
+// from, where the request Host is of the subdomain gateway form
+// <cid>.ipfs.<domain> and the first label is a CIDv1.
+func subdomainRoot(req *http.Request) (cid.Cid, string, bool) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 || !strings.EqualFold(labels[1], "ipfs") {
+		return cid.Undef, "", false
+	}
+	label := strings.ToLower(labels[0])
+	rootCid, err := cid.Parse(label)
+	if err != nil || rootCid.Version() == 0 {
+		return cid.Undef, "", false
+	}
+	return rootCid, label, true
+}
+
+// queryRoot returns the root CID, and the string it was parsed from, where the
+// request has a valid CID in a cid query parameter.
+func queryRoot(req *http.Request) (cid.Cid, string, bool) {
+	s := req.URL.Query().Get("cid")
+	rootCid, err := cid.Parse(s)
+	if err != nil {
+		return cid.Undef, "", false
+	}
+	return rootCid, s, true
+}
+
+// CanonicalRedirect checks whether the root CID of a path-style request
+// (/ipfs/<cid>[/<path>]) is in its canonical string form, as produced by
+// trustlessutils.CanonicalCid: a CIDv1 in base32. Where it is not, such as a
+// CIDv0 or a CIDv1 in another multibase, the URL of the canonical form of the
+// request is returned along with true, and a server may choose to respond with
+// a 301 (Moved Permanently) redirect to that URL so that caches only hold one
+// copy of each response. The rest of the path and the query string are
+// preserved as-is.
+//
+// Requests that are already canonical, subdomain-style requests, which are
+// always CIDv1, requests with a cid query parameter, and requests that do not
+// have a valid root CID return false.
+func CanonicalRedirect(req *http.Request) (string, bool) {
+	if _, _, ok := subdomainRoot(req); ok || req.URL.Query().Has("cid") {
+		return "", false
+	}
+	escaped := strings.TrimLeft(req.URL.EscapedPath(), "/")
+	if !strings.HasPrefix(escaped, "ipfs/") {
+		return "", false
+	}
+	escaped = strings.TrimLeft(strings.TrimPrefix(escaped, "ipfs/"), "/")
+	cidStr, rest, _ := strings.Cut(escaped, "/")
+	root, err := cid.Parse(cidStr)
+	if err != nil {
+		return "", false
+	}
+	canonical := trustlessutils.CanonicalCid(root).String()
+	if canonical == cidStr {
+		return "", false
+	}
+	location := "/ipfs/" + canonical
+	if rest != "" {
+		location += "/" + rest
+	}
+	if req.URL.RawQuery != "" {
+		location += "?" + req.URL.RawQuery
+	}
+	return location, true
+}
@@ -1,17 +1,59 @@
 package trustlesshttp_test
 
 import (
+	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
 	trustlessutils "github.com/ipld/go-trustless-utils"
 	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/multiformats/go-multibase"
 	"github.com/stretchr/testify/require"
 )
 
 var testCidV1 = cid.MustParse("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi")
+var testCidV0 = cid.MustParse("QmVXsSVjwxMsCwKRCUxEkGb4f4B98gXVy3ih3v4otvcURK")
+
+func TestCheckQuery(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		query      string
+		additional []string
+		err        string
+	}{
+		{"empty", "", nil, ""},
+		{"canonical", "dag-scope=entity&entity-bytes=0:100", nil, ""},
+		{"all known", "format=car&dag-scope=all&entity-bytes=0:*&car-version=1&car-order=dfs&car-dups=y&filename=a.car&download=true&pathing=unixfs&selector=oQ", nil, ""},
+		{"empty value", "dag-scope=", nil, ""},
+		{"repeated dag-scope (err)", "dag-scope=entity&dag-scope=all", nil, `repeated parameter: "dag-scope"`},
+		{"repeated entity-bytes (err)", "entity-bytes=0:10&dag-scope=all&entity-bytes=10:20", nil, `repeated parameter: "entity-bytes"`},
+		{"repeated format (err)", "format=car&format=raw", nil, `repeated parameter: "format"`},
+		{"repeated car-dups (err)", "car-dups=y&car-dups=n", nil, `repeated parameter: "car-dups"`},
+		{"repeated identical (err)", "format=car&format=car", nil, `repeated parameter: "format"`},
+		{"unknown (err)", "dag-scope=all&bork=1", nil, `unsupported parameter: "bork"`},
+		{"unknown flag (err)", "bork", nil, `unsupported parameter: "bork"`},
+		{"case sensitive (err)", "Dag-Scope=all", nil, `unsupported parameter: "Dag-Scope"`},
+		{"additional", "dag-scope=all&bork=1", []string{"bork"}, ""},
+		{"repeated additional (err)", "bork=1&bork=2", []string{"bork"}, `repeated parameter: "bork"`},
+		{"malformed escape (err)", "dag-scope=%zz", nil, "invalid query string"},
+		{"semicolon (err)", "dag-scope=all;format=car", nil, "invalid query string"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &http.Request{URL: &url.URL{RawQuery: tc.query}}
+			err := trustlesshttp.CheckQuery(req, tc.additional...)
+			if tc.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
 
 func TestParseScope(t *testing.T) {
 	for _, tc := range []struct {
@@ -41,6 +83,33 @@ func TestParseScope(t *testing.T) {
 	}
 }
 
+func TestParsePathing(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		query    string
+		expected trustlessutils.Pathing
+		err      string
+	}{
+		{"no query", "", trustlessutils.PathingUnixFS, ""},
+		{"unixfs", "pathing=unixfs", trustlessutils.PathingUnixFS, ""},
+		{"datamodel", "pathing=datamodel", trustlessutils.PathingDataModel, ""},
+		{"bork", "pathing=bork", "", "invalid pathing parameter"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &http.Request{}
+			req.URL = &url.URL{RawQuery: tc.query}
+			p, err := trustlesshttp.ParsePathing(req)
+			if tc.err == "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, p)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
 func TestByteRange(t *testing.T) {
 	for _, tc := range []struct {
 		name     string
@@ -72,6 +141,51 @@ func TestByteRange(t *testing.T) {
 	}
 }
 
+func TestParseSelector(t *testing.T) {
+	parentsSelector := `{"R":{"l":{"depth":5},":>":{"f":{"f>":{"Parents":{"|":[{".":{}},{"a":{">":{"@":{}}}}]}}}}}}`
+	expected, err := trustlessutils.DecodeSelector(parentsSelector)
+	require.NoError(t, err)
+	encoded, err := trustlessutils.EncodeSelector(expected)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name              string
+		query             string
+		maxSize           int
+		maxRecursionDepth int64
+		expected          bool
+		err               string
+	}{
+		{name: "no query"},
+		{name: "dag-json", query: "selector=" + url.QueryEscape(parentsSelector), expected: true},
+		{name: "dag-cbor", query: "selector=" + encoded, expected: true},
+		{name: "exact depth", query: "selector=" + encoded, maxRecursionDepth: 5, expected: true},
+		{name: "too deep", query: "selector=" + encoded, maxRecursionDepth: 4, err: "invalid selector parameter; invalid selector: recursive explore depth limit 5 exceeds maximum of 4"},
+		{name: "too long", query: "selector=" + encoded, maxSize: len(encoded) - 1, err: "invalid selector parameter; exceeds maximum length"},
+		{name: "unlimited recursion", query: "selector=" + url.QueryEscape(`{"R":{"l":{"none":{}},":>":{"a":{">":{"@":{}}}}}}`), err: "invalid selector parameter; invalid selector: recursive explore must have a depth limit"},
+		{name: "not a selector", query: "selector=" + url.QueryEscape(`{"bork":{}}`), err: "invalid selector parameter; invalid selector"},
+		{name: "bad encoding", query: "selector=!!!", err: "invalid selector parameter; failed to decode selector"},
+		{name: "bad dag-json", query: "selector=" + url.QueryEscape(`{"R":`), err: "invalid selector parameter; failed to decode dag-json selector"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &http.Request{}
+			req.URL = &url.URL{RawQuery: tc.query}
+			sel, err := trustlesshttp.ParseSelector(req, tc.maxSize, tc.maxRecursionDepth)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				require.Nil(t, sel)
+				return
+			}
+			require.NoError(t, err)
+			if tc.expected {
+				require.True(t, datamodel.DeepEqual(expected, sel))
+			} else {
+				require.Nil(t, sel)
+			}
+		})
+	}
+}
+
 func ptr[T any](v T) *T {
 	return &v
 }
@@ -80,6 +194,7 @@ func TestParseFilename(t *testing.T) {
 	carAccepts := []trustlesshttp.ContentType{trustlesshttp.DefaultContentType()}
 	rawAccepts := []trustlesshttp.ContentType{trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw)}
 	bothAccepts := []trustlesshttp.ContentType{trustlesshttp.DefaultContentType(), trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw)}
+	ipnsAccepts := []trustlesshttp.ContentType{trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeIpnsRecord)}
 
 	for _, tc := range []struct {
 		name     string
@@ -98,6 +213,9 @@ func TestParseFilename(t *testing.T) {
 		{"bad extension (err)", "filename=bork.exe", carAccepts, "", "invalid filename parameter; unsupported extension: \".exe\""},
 		{".car with raw accept (err)", "filename=boop.car", rawAccepts, "", ".car extension requires CAR response format"},
 		{".bin with CAR accept (err)", "filename=boop.bin", carAccepts, "", ".bin extension requires raw response format"},
+		{"boop.ipns-record with ipns-record accept", "filename=boop.ipns-record", ipnsAccepts, "boop.ipns-record", ""},
+		{".ipns-record with CAR accept (err)", "filename=boop.ipns-record", carAccepts, "", ".ipns-record extension requires ipns-record response format"},
+		{".car with ipns-record accept (err)", "filename=boop.car", ipnsAccepts, "", ".car extension requires CAR response format"},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			req := &http.Request{}
@@ -128,6 +246,9 @@ func TestCheckFormat(t *testing.T) {
 		{"format=raw", "", "format=raw", []trustlesshttp.ContentType{trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw)}, ""},
 		{"car accept", "application/vnd.ipld.car", "", []trustlesshttp.ContentType{trustlesshttp.DefaultContentType()}, ""},
 		{"raw accept", "application/vnd.ipld.raw", "", []trustlesshttp.ContentType{trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw)}, ""},
+		{"format=ipns-record", "", "format=ipns-record", []trustlesshttp.ContentType{trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeIpnsRecord)}, ""},
+		{"ipns-record accept", "application/vnd.ipfs.ipns-record", "", []trustlesshttp.ContentType{trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeIpnsRecord)}, ""},
+		{"format=ipns-record with car Accept (format wins)", "application/vnd.ipld.car", "format=ipns-record", []trustlesshttp.ContentType{trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeIpnsRecord)}, ""},
 		{"raw accept plus garbage", "application/vnd.ipld.raw; ignore; this", "", []trustlesshttp.ContentType{trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw)}, ""},
 		{"accept dups", "application/vnd.ipld.car; dups=y", "", []trustlesshttp.ContentType{trustlesshttp.DefaultContentType()}, ""},
 		{"accept no dups", "application/vnd.ipld.car; dups=n", "", []trustlesshttp.ContentType{trustlesshttp.DefaultContentType().WithDuplicates(false)}, ""},
@@ -183,6 +304,8 @@ func TestParseContentType(t *testing.T) {
 		{"empty (err)", "", false, trustlesshttp.ContentType{}},
 		{"car", "application/vnd.ipld.car", true, trustlesshttp.DefaultContentType()},
 		{"raw", "application/vnd.ipld.raw", true, trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeRaw)},
+		{"ipns-record", "application/vnd.ipfs.ipns-record", true, trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeIpnsRecord)},
+		{"ipns-record ignores car params", "application/vnd.ipfs.ipns-record; dups=n; order=unk", true, trustlesshttp.DefaultContentType().WithMimeType(trustlesshttp.MimeTypeIpnsRecord)},
 		{"*/*", "*/*", false, trustlesshttp.ContentType{}},
 		{"application/*", "application/*", false, trustlesshttp.ContentType{}},
 		{"dups", "application/vnd.ipld.car; dups=y", true, trustlesshttp.DefaultContentType()},
@@ -230,6 +353,7 @@ func TestParseAccept(t *testing.T) {
 		{"complete (shuffle)", "application/vnd.ipld.car;version=1;dups=y;order=dfs;", []trustlesshttp.ContentType{{MimeType: trustlesshttp.MimeTypeCar, Duplicates: true, Order: trustlesshttp.ContentTypeOrderDfs, Quality: 1.0}}},
 		{"complete (cruft)", "application/vnd.ipld.car;;version=1; bip ;   dups=n ;bop;order=dfs;--", []trustlesshttp.ContentType{{MimeType: trustlesshttp.MimeTypeCar, Duplicates: false, Order: trustlesshttp.ContentTypeOrderDfs, Quality: 1.0}}},
 		{"q", "application/vnd.ipld.car; order=dfs; q=0.77; dups=n", []trustlesshttp.ContentType{{MimeType: trustlesshttp.MimeTypeCar, Duplicates: false, Order: trustlesshttp.ContentTypeOrderDfs, Quality: 0.77}}},
+		{"ipns-record", "application/vnd.ipfs.ipns-record;q=0.5", []trustlesshttp.ContentType{{MimeType: trustlesshttp.MimeTypeIpnsRecord, Duplicates: true, Order: trustlesshttp.ContentTypeOrderDfs, Quality: 0.5}}},
 		{"q=bork", "application/vnd.ipld.car; order=dfs; q=bork; dups=n", []trustlesshttp.ContentType{}},
 		{"q=-1", "application/vnd.ipld.car; order=dfs; q=-0.1; dups=n", []trustlesshttp.ContentType{}},
 
@@ -289,3 +413,211 @@ func TestParseUrlPath(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRequestPath(t *testing.T) {
+	base36 := testCidV1.Encode(multibase.MustNewEncoder(multibase.Base36))
+	require.True(t, strings.HasPrefix(base36, "k"))
+
+	for _, tc := range []struct {
+		name         string
+		host         string
+		path         string
+		expectedRoot cid.Cid
+		expectedPath string
+		err          string
+	}{
+		{"path style", "example.com", "/ipfs/" + testCidV1.String() + "/foo/bar", testCidV1, "foo/bar", ""},
+		{"path style with port", "localhost:8080", "/ipfs/" + testCidV1.String(), testCidV1, "", ""},
+		{"path style v0", "example.com", "/ipfs/" + testCidV0.String(), testCidV0, "", ""},
+		{"path style on gateway host", "ipfs.example.com", "/ipfs/" + testCidV1.String(), testCidV1, "", ""},
+		{"path style not found (err)", "example.com", "/foo", cid.Undef, "", "not found"},
+		{"subdomain", testCidV1.String() + ".ipfs.example.com", "/", testCidV1, "", ""},
+		{"subdomain with path", testCidV1.String() + ".ipfs.example.com", "/foo//bar/", testCidV1, "foo/bar", ""},
+		{"subdomain localhost with port", testCidV1.String() + ".ipfs.localhost:8080", "/foo", testCidV1, "foo", ""},
+		{"subdomain uppercase", strings.ToUpper(testCidV1.String()) + ".ipfs.example.com", "/", testCidV1, "", ""},
+		{"subdomain base36", base36 + ".ipfs.example.com", "/foo", testCidV1, "foo", ""},
+		{"subdomain v0 is path style (err)", testCidV0.String() + ".ipfs.example.com", "/", cid.Undef, "", "not found"},
+		{"non-cid subdomain is path style (err)", "nope.ipfs.example.com", "/", cid.Undef, "", "not found"},
+		{"path style on gateway subdomain", "gw.ipfs.example.com", "/ipfs/" + testCidV1.String() + "/foo", testCidV1, "foo", ""},
+		{"subdomain path is content path", testCidV1.String() + ".ipfs.example.com", "/ipfs/" + testCidV0.String(), testCidV1, "ipfs/" + testCidV0.String(), ""},
+		{"subdomain mixed case ipfs label", testCidV1.String() + ".IPFS.example.com", "/foo", testCidV1, "foo", ""},
+		{"cid parameter", "example.com", "/?cid=" + testCidV1.String(), testCidV1, "", ""},
+		{"cid parameter with path", "example.com", "/foo//bar/?cid=" + testCidV1.String() + "&format=car", testCidV1, "foo/bar", ""},
+		{"cid parameter v0", "example.com", "/foo?cid=" + testCidV0.String(), testCidV0, "foo", ""},
+		{"cid parameter base36", "example.com", "/?cid=" + base36, testCidV1, "", ""},
+		{"cid parameter path is content path", "example.com", "/ipfs/" + testCidV0.String() + "?cid=" + testCidV1.String(), testCidV1, "ipfs/" + testCidV0.String(), ""},
+		{"invalid cid parameter (err)", "example.com", "/?cid=bork", cid.Undef, "", "failed to parse root CID"},
+		{"empty cid parameter (err)", "example.com", "/ipfs/" + testCidV1.String() + "?cid=", cid.Undef, "", "failed to parse root CID"},
+		{"cid parameter on subdomain (err)", testCidV1.String() + ".ipfs.example.com", "/?cid=" + testCidV1.String(), cid.Undef, "", "cid parameter on a subdomain request"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.path)
+			require.NoError(t, err)
+			req := &http.Request{Host: tc.host, URL: u}
+			root, path, err := trustlesshttp.ParseRequestPath(req)
+			if tc.err == "" {
+				require.NoError(t, err)
+				require.True(t, tc.expectedRoot.Equals(root))
+				require.Equal(t, tc.expectedPath, path.String())
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestCanonicalRedirect(t *testing.T) {
+	v0Upgraded := trustlessutils.CanonicalCid(testCidV0).String()
+	base36 := testCidV1.Encode(multibase.MustNewEncoder(multibase.Base36))
+
+	for _, tc := range []struct {
+		name     string
+		host     string
+		url      string
+		expected string
+	}{
+		{"canonical", "example.com", "/ipfs/" + testCidV1.String() + "/foo?format=car", ""},
+		{"v0", "example.com", "/ipfs/" + testCidV0.String(), "/ipfs/" + v0Upgraded},
+		{"v0 with path and query", "example.com", "/ipfs/" + testCidV0.String() + "/foo/b%20r?format=car&dag-scope=entity", "/ipfs/" + v0Upgraded + "/foo/b%20r?format=car&dag-scope=entity"},
+		{"v0 with extra slashes", "example.com", "/ipfs//" + testCidV0.String() + "/foo", "/ipfs/" + v0Upgraded + "/foo"},
+		{"base36", "example.com", "/ipfs/" + base36 + "/foo", "/ipfs/" + testCidV1.String() + "/foo"},
+		{"uppercase base32", "example.com", "/ipfs/" + strings.ToUpper(testCidV1.String()), "/ipfs/" + testCidV1.String()},
+		{"bad cid", "example.com", "/ipfs/bork", ""},
+		{"not ipfs", "example.com", "/ipns/example.com", ""},
+		{"subdomain", base36 + ".ipfs.example.com", "/ipfs/" + testCidV0.String(), ""},
+		{"v0 on gateway subdomain", "gw.ipfs.example.com", "/ipfs/" + testCidV0.String(), "/ipfs/" + v0Upgraded},
+		{"cid parameter", "example.com", "/ipfs/" + testCidV0.String() + "?cid=" + testCidV0.String(), ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.url)
+			require.NoError(t, err)
+			req := &http.Request{Host: tc.host, URL: u}
+			location, ok := trustlesshttp.CanonicalRedirect(req)
+			require.Equal(t, tc.expected != "", ok)
+			require.Equal(t, tc.expected, location)
+			if ok {
+				u, err := url.Parse(location)
+				require.NoError(t, err)
+				_, ok := trustlesshttp.CanonicalRedirect(&http.Request{Host: tc.host, URL: u})
+				require.False(t, ok, "redirect target should be canonical")
+			}
+		})
+	}
+}
+
+// roundTripRequest encodes a request with Request.UrlPath, passes it through
+// HTTP request parsing as a server would receive it, then parses it back into a
+// Request.
+func roundTripRequest(t testing.TB, request trustlessutils.Request, subdomain bool) (trustlessutils.Request, error) {
+	urlPath, err := request.UrlPath()
+	if err != nil {
+		return trustlessutils.Request{}, err
+	}
+	target := "http://example.com/ipfs/" + request.Root.String() + urlPath
+	if subdomain {
+		if !strings.HasPrefix(urlPath, "/") {
+			urlPath = "/" + urlPath
+		}
+		target = "http://" + request.Root.String() + ".ipfs.example.com" + urlPath
+	}
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	require.NoError(t, trustlesshttp.CheckQuery(req))
+
+	root, path, err := trustlesshttp.ParseRequestPath(req)
+	if err != nil {
+		return trustlessutils.Request{}, err
+	}
+	scope, err := trustlesshttp.ParseScope(req)
+	require.NoError(t, err)
+	byteRange, err := trustlesshttp.ParseByteRange(req)
+	require.NoError(t, err)
+	return trustlessutils.Request{Root: root, Path: path.String(), Scope: scope, Bytes: byteRange}, nil
+}
+
+func TestUrlPathRoundTrip(t *testing.T) {
+	for _, name := range []string{
+		"plain",
+		"with space",
+		"100%",
+		"%2F",
+		"%2f",
+		"%252F",
+		"%",
+		"%%",
+		"%zz",
+		"?",
+		"a?b=c",
+		"#",
+		"a#b",
+		"&",
+		"+",
+		";",
+		",",
+		"=",
+		"~",
+		"@",
+		"...",
+		".hidden",
+		"trailing.",
+		"\\",
+		"\x00",
+		"\t\r\n",
+		"\x7f",
+		"\xff\xfe",
+		"\xc3",
+		"é",
+		"ж",
+		"日本語",
+		"🐢",
+		"a\u0301", // NFD, must not be normalised
+	} {
+		for _, subdomain := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%q subdomain=%t", name, subdomain), func(t *testing.T) {
+				request := trustlessutils.Request{
+					Root:  testCidV1,
+					Path:  "dir/" + name + "/" + name,
+					Scope: trustlessutils.DagScopeEntity,
+					Bytes: &trustlessutils.ByteRange{From: 10},
+				}
+				actual, err := roundTripRequest(t, request, subdomain)
+				require.NoError(t, err)
+				require.Equal(t, request, actual)
+			})
+		}
+	}
+
+	for _, path := range []string{".", "..", "a/./b", "a/../b"} {
+		t.Run(fmt.Sprintf("%q rejected", path), func(t *testing.T) {
+			_, err := roundTripRequest(t, trustlessutils.Request{Root: testCidV1, Path: path}, false)
+			require.ErrorIs(t, err, trustlessutils.ErrBadPathSegment)
+		})
+	}
+
+	for _, path := range []string{"/foo%2Fbar", "/foo%2fbar", "/%2E%2E/foo", "/foo/./bar"} {
+		t.Run(fmt.Sprintf("%q rejected by server", path), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/ipfs/"+testCidV1.String()+path, nil)
+			_, _, err := trustlesshttp.ParseRequestPath(req)
+			require.ErrorIs(t, err, trustlessutils.ErrBadPathSegment)
+		})
+	}
+}
+
+func FuzzUrlPathRoundTrip(f *testing.F) {
+	for _, seed := range []string{"", "a/b/c", "%2F", "100%/?#", "\xff\xfe/\x00", "a//b/", "./..", "日本語/🐢"} {
+		f.Add(seed, false)
+		f.Add(seed, true)
+	}
+	f.Fuzz(func(t *testing.T, path string, subdomain bool) {
+		request := trustlessutils.Request{Root: testCidV1, Path: path, Scope: trustlessutils.DagScopeAll}
+		actual, err := roundTripRequest(t, request, subdomain)
+		if trustlessutils.CheckPath(path) != nil {
+			require.ErrorIs(t, err, trustlessutils.ErrBadPathSegment)
+			return
+		}
+		require.NoError(t, err)
+		// empty segments are collapsed, everything else is preserved exactly
+		request.Path = datamodel.ParsePath(path).String()
+		require.Equal(t, request, actual)
+	})
+}
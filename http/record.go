@@ -0,0 +1,54 @@
+package trustlesshttp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/boxo/ipns"
+	"go.uber.org/multierr"
+)
+
+var (
+	ErrBadIpnsName   = errors.New("invalid IPNS name")
+	ErrBadIpnsRecord = errors.New("invalid IPNS record")
+)
+
+// VerifyIpnsRecord verifies the body of an application/vnd.ipfs.ipns-record
+// response against the IPNS name it was requested for, which may be provided
+// either bare or prefixed with /ipns/. The record must be correctly signed by
+// the key that the name is derived from, and must not have expired.
+//
+// The verified record is returned so that its Value and TTL may be inspected.
+// Errors are wrapped with ErrBadIpnsName if the name could not be parsed, or
+// ErrBadIpnsRecord if the record failed verification.
+// See https://specs.ipfs.tech/ipns/ipns-record/#record-verification
+func VerifyIpnsRecord(name string, data []byte) (*ipns.Record, error) {
+	n, err := ipns.NameFromString(name)
+	if err != nil {
+		// TODO: post-1.19: fmt.Errorf("%w: %w", ErrBadIpnsName, err)
+		return nil, multierr.Combine(ErrBadIpnsName, err)
+	}
+	rec, err := ipns.UnmarshalRecord(data)
+	if err != nil {
+		// TODO: post-1.19: fmt.Errorf("%w: %w", ErrBadIpnsRecord, err)
+		return nil, multierr.Combine(ErrBadIpnsRecord, err)
+	}
+	if err := ipns.ValidateWithName(rec, n); err != nil {
+		// TODO: post-1.19: fmt.Errorf("%w: %w", ErrBadIpnsRecord, err)
+		return nil, multierr.Combine(ErrBadIpnsRecord, fmt.Errorf("record does not verify for %s: %w", n, err))
+	}
+	return rec, nil
+}
+
+// RecordCacheControl returns a Cache-Control header value for an
+// application/vnd.ipfs.ipns-record response containing the provided record,
+// to be used in place of the immutable ResponseCacheControlHeader. The max-age
+// is the TTL of the record; a record without a TTL, or with a zero TTL,
+// results in a response that must not be cached.
+func RecordCacheControl(rec *ipns.Record) string {
+	ttl, err := rec.TTL()
+	if err != nil {
+		return cacheControl(0)
+	}
+	return cacheControl(ttl)
+}
@@ -0,0 +1,78 @@
+package trustlesshttp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyIpnsRecord(t *testing.T) {
+	sk, name := testutil.IpnsKey(t, 1)
+	otherSk, otherName := testutil.IpnsKey(t, 2)
+	value := "/ipfs/" + testCidV1.String() + "/foo"
+	eol := time.Now().Add(time.Hour)
+
+	valid := testutil.MakeIpnsRecord(t, sk, value, 1, eol, 5*time.Minute)
+	expired := testutil.MakeIpnsRecord(t, sk, value, 1, time.Now().Add(-time.Hour), 5*time.Minute)
+	wrongKey := testutil.MakeIpnsRecord(t, otherSk, value, 1, eol, 5*time.Minute)
+	corrupt := append([]byte{}, valid...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	for _, tc := range []struct {
+		name      string
+		ipnsName  string
+		record    []byte
+		expectErr error
+	}{
+		{"valid", name.String(), valid, nil},
+		{"valid with prefix", ipns.NamespacePrefix + name.String(), valid, nil},
+		{"other name", otherName.String(), wrongKey, nil},
+		{"bad name", "bork", valid, trustlesshttp.ErrBadIpnsName},
+		{"wrong name", otherName.String(), valid, trustlesshttp.ErrBadIpnsRecord},
+		{"wrong key", name.String(), wrongKey, trustlesshttp.ErrBadIpnsRecord},
+		{"expired", name.String(), expired, trustlesshttp.ErrBadIpnsRecord},
+		{"corrupt", name.String(), corrupt, trustlesshttp.ErrBadIpnsRecord},
+		{"garbage", name.String(), []byte("not a record"), trustlesshttp.ErrBadIpnsRecord},
+		{"empty", name.String(), []byte{}, trustlesshttp.ErrBadIpnsRecord},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, err := trustlesshttp.VerifyIpnsRecord(tc.ipnsName, tc.record)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			p, err := rec.Value()
+			require.NoError(t, err)
+			require.Equal(t, value, p.String())
+			ttl, err := rec.TTL()
+			require.NoError(t, err)
+			require.Equal(t, 5*time.Minute, ttl)
+		})
+	}
+}
+
+func TestRecordCacheControl(t *testing.T) {
+	sk, name := testutil.IpnsKey(t, 1)
+	eol := time.Now().Add(time.Hour)
+
+	for _, tc := range []struct {
+		name     string
+		ttl      time.Duration
+		expected string
+	}{
+		{"ttl", 5 * time.Minute, "public, max-age=300"},
+		{"sub-second ttl", 500 * time.Millisecond, "no-cache"},
+		{"zero ttl", 0, "no-cache"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, err := trustlesshttp.VerifyIpnsRecord(name.String(), testutil.MakeIpnsRecord(t, sk, "/ipfs/"+testCidV1.String(), 1, eol, tc.ttl))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, trustlesshttp.RecordCacheControl(rec))
+		})
+	}
+}
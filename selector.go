@@ -0,0 +1,334 @@
+package trustlessutils
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// EncodeSelector encodes an IPLD selector into a compact form suitable for use
+// as the value of the non-standard "selector" query parameter: the unpadded
+// base64url encoding of the dag-cbor form of the selector.
+func EncodeSelector(sel datamodel.Node) (string, error) {
+	byts, err := selectorBytes(sel)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(byts), nil
+}
+
+// selectorBytes returns the dag-cbor form of the selector.
+func selectorBytes(sel datamodel.Node) ([]byte, error) {
+	byts, err := ipld.Encode(sel, dagcbor.Encode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode selector: %w", err)
+	}
+	return byts, nil
+}
+
+// DecodeSelector decodes the value of the non-standard "selector" query
+// parameter. A value beginning with "{" is decoded as dag-json, otherwise it
+// is decoded as the unpadded base64url encoding of dag-cbor as produced by
+// EncodeSelector.
+//
+// DecodeSelector only decodes the value, it does not check that it is a valid
+// selector; see CheckSelector.
+func DecodeSelector(s string) (datamodel.Node, error) {
+	if strings.HasPrefix(s, "{") {
+		sel, err := ipld.Decode([]byte(s), dagjson.Decode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode dag-json selector: %w", err)
+		}
+		return sel, nil
+	}
+	byts, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode selector: %w", err)
+	}
+	sel, err := ipld.Decode(byts, dagcbor.Decode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode dag-cbor selector: %w", err)
+	}
+	return sel, nil
+}
+
+// CheckSelector checks that sel is a valid IPLD selector and that every
+// recursive explore within it has an explicit depth limit no greater than
+// maxRecursionDepth. An unlimited ("none") recursion limit is rejected. Where
+// recursive explores are nested, each multiplies the depth that can be reached
+// by those within it, so the product of their depth limits must also be no
+// greater than maxRecursionDepth.
+func CheckSelector(sel datamodel.Node, maxRecursionDepth int64) error {
+	if _, err := selector.CompileSelector(sel); err != nil {
+		return fmt.Errorf("invalid selector: %w", err)
+	}
+	return checkRecursionLimits(sel, maxRecursionDepth, 1)
+}
+
+// checkRecursionLimits walks the selector checking the limit of each recursive
+// explore, where depth is the product of the depth limits of the recursive
+// explores enclosing n.
+func checkRecursionLimits(n datamodel.Node, maxRecursionDepth int64, depth int64) error {
+	switch n.Kind() {
+	case datamodel.Kind_Map:
+		itr := n.MapIterator()
+		for !itr.Done() {
+			k, v, err := itr.Next()
+			if err != nil {
+				return err
+			}
+			childDepth := depth
+			if ks, err := k.AsString(); err == nil && ks == selector.SelectorKey_ExploreRecursive {
+				if childDepth, err = checkRecursionLimit(v, maxRecursionDepth, depth); err != nil {
+					return err
+				}
+			}
+			if err := checkRecursionLimits(v, maxRecursionDepth, childDepth); err != nil {
+				return err
+			}
+		}
+	case datamodel.Kind_List:
+		itr := n.ListIterator()
+		for !itr.Done() {
+			_, v, err := itr.Next()
+			if err != nil {
+				return err
+			}
+			if err := checkRecursionLimits(v, maxRecursionDepth, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRecursionLimit checks the limit of a recursive explore enclosed by
+// recursive explores reaching depth, returning the depth reachable within it.
+func checkRecursionLimit(explore datamodel.Node, maxRecursionDepth int64, depth int64) (int64, error) {
+	limit, err := explore.LookupByString(selector.SelectorKey_Limit)
+	if err != nil {
+		return 0, errors.New("invalid selector: recursive explore is missing a limit")
+	}
+	depthLimit, err := limit.LookupByString(selector.SelectorKey_LimitDepth)
+	if err != nil {
+		return 0, errors.New("invalid selector: recursive explore must have a depth limit")
+	}
+	d, err := depthLimit.AsInt()
+	if err != nil {
+		return 0, errors.New("invalid selector: recursive explore depth limit must be an integer")
+	}
+	if d > maxRecursionDepth {
+		return 0, fmt.Errorf("invalid selector: recursive explore depth limit %d exceeds maximum of %d", d, maxRecursionDepth)
+	}
+	if d <= 1 {
+		return depth, nil
+	}
+	if depth > maxRecursionDepth/d {
+		return 0, fmt.Errorf("invalid selector: nested recursive explore depth limits reach a depth of more than the maximum of %d", maxRecursionDepth)
+	}
+	return depth * d, nil
+}
+
+// ErrSelectorNotExpressible is returned by RequestFromSelector when a selector
+// has no equivalent Trustless Gateway request.
+var ErrSelectorNotExpressible = errors.New("selector is not expressible as a Trustless Gateway request")
+
+// RequestFromSelector analyses an IPLD selector and, if it is equivalent to one
+// produced by Request.Selector for some combination of Path, Scope and Bytes,
+// returns a Request with those fields set. The Root of the returned Request is
+// not set and should be supplied by the caller.
+//
+// Selectors that path through plain IPLD data model fields rather than UnixFS
+// directory entries, as produced with PathingDataModel, are also recognised
+// and will have Pathing set on the returned Request. Where a selector is valid
+// for both forms, PathingUnixFS is preferred.
+//
+// This allows selectors received from callers that are not aware of the
+// Trustless Gateway protocol to be fetched over HTTP where possible. If the
+// selector has no equivalent Request, an error wrapping
+// ErrSelectorNotExpressible is returned.
+func RequestFromSelector(sel datamodel.Node) (Request, error) {
+	request, err := unixfsRequestFromSelector(sel)
+	if err == nil {
+		return request, nil
+	}
+	if request, ok := dataModelRequestFromSelector(sel); ok {
+		return request, nil
+	}
+	return Request{}, err
+}
+
+func unixfsRequestFromSelector(sel datamodel.Node) (Request, error) {
+	var segments []string
+	n := sel
+	for {
+		seg, next, ok := unixfsPathSegment(n)
+		if !ok {
+			break
+		}
+		segments = append(segments, seg)
+		n = next
+	}
+
+	request := Request{Path: strings.Join(segments, "/")}
+	switch {
+	case datamodel.DeepEqual(n, DagScopeAll.TerminalSelectorSpec().Node()):
+		request.Scope = DagScopeAll
+	case datamodel.DeepEqual(n, DagScopeEntity.TerminalSelectorSpec().Node()):
+		request.Scope = DagScopeEntity
+	case datamodel.DeepEqual(n, DagScopeBlock.TerminalSelectorSpec().Node()):
+		request.Scope = DagScopeBlock
+	default:
+		br, ok := unixfsByteRange(n)
+		if !ok {
+			return Request{}, fmt.Errorf("%w: unrecognised terminal selector", ErrSelectorNotExpressible)
+		}
+		request.Scope = DagScopeEntity
+		request.Bytes = &br
+	}
+
+	// make sure we can produce exactly the same selector, which guards against
+	// path segments and byte ranges that don't round-trip
+	if !datamodel.DeepEqual(sel, request.Selector()) {
+		return Request{}, fmt.Errorf("%w: selector does not round-trip", ErrSelectorNotExpressible)
+	}
+	return request, nil
+}
+
+func dataModelRequestFromSelector(sel datamodel.Node) (Request, bool) {
+	var segments []string
+	n := sel
+	for {
+		seg, next, ok := exploreFieldsSegment(n)
+		if !ok {
+			break
+		}
+		segments = append(segments, seg)
+		n = next
+	}
+
+	request := Request{Path: strings.Join(segments, "/"), Pathing: PathingDataModel}
+	switch {
+	case datamodel.DeepEqual(n, DagScopeAll.TerminalSelectorSpec().Node()):
+		request.Scope = DagScopeAll
+	case datamodel.DeepEqual(n, DagScopeBlock.TerminalSelectorSpec().Node()):
+		request.Scope = DagScopeBlock
+	default:
+		return Request{}, false
+	}
+	return request, datamodel.DeepEqual(sel, request.Selector())
+}
+
+// unixfsPathSegment matches a single path segment as produced by
+// unixfsnode.UnixFSPathSelectorBuilder, of the form
+// {"~":{"as":"unixfs",">":{"f":{"f>":{"<segment>":<next>}}}}}.
+func unixfsPathSegment(n datamodel.Node) (string, datamodel.Node, bool) {
+	inner, ok := unixfsInterpretAs(n)
+	if !ok {
+		return "", nil, false
+	}
+	return exploreFieldsSegment(inner)
+}
+
+// exploreFieldsSegment matches a single field selector of the form
+// {"f":{"f>":{"<segment>":<next>}}}.
+func exploreFieldsSegment(n datamodel.Node) (string, datamodel.Node, bool) {
+	if n.Kind() != datamodel.Kind_Map || n.Length() != 1 {
+		return "", nil, false
+	}
+	fields, err := traverseKeys(n, selector.SelectorKey_ExploreFields, selector.SelectorKey_Fields)
+	if err != nil || fields.Kind() != datamodel.Kind_Map || fields.Length() != 1 {
+		return "", nil, false
+	}
+	k, next, err := fields.MapIterator().Next()
+	if err != nil {
+		return "", nil, false
+	}
+	seg, err := k.AsString()
+	if err != nil {
+		return "", nil, false
+	}
+	return seg, next, true
+}
+
+// unixfsByteRange matches the entity-bytes terminal selector produced by
+// Request.Selector and returns the equivalent ByteRange.
+func unixfsByteRange(n datamodel.Node) (ByteRange, bool) {
+	inner, ok := unixfsInterpretAs(n)
+	if !ok {
+		return ByteRange{}, false
+	}
+	subset, err := traverseKeys(inner, selector.SelectorKey_ExploreUnion, "0", selector.SelectorKey_Matcher, selector.SelectorKey_Subset)
+	if err != nil {
+		return ByteRange{}, false
+	}
+	fromNode, err := subset.LookupByString(selector.SelectorKey_From)
+	if err != nil {
+		return ByteRange{}, false
+	}
+	from, err := fromNode.AsInt()
+	if err != nil {
+		return ByteRange{}, false
+	}
+	toNode, err := subset.LookupByString(selector.SelectorKey_To)
+	if err != nil {
+		return ByteRange{}, false
+	}
+	to, err := toNode.AsInt()
+	if err != nil {
+		return ByteRange{}, false
+	}
+	br := ByteRange{From: from}
+	switch {
+	case to == math.MaxInt64:
+	case to > 0:
+		to-- // selector is exclusive, so decrement the end
+		br.To = &to
+	default:
+		br.To = &to
+	}
+	return br, true
+}
+
+// unixfsInterpretAs matches {"~":{"as":"unixfs",">":<inner>}} and returns
+// the inner selector.
+func unixfsInterpretAs(n datamodel.Node) (datamodel.Node, bool) {
+	if n.Kind() != datamodel.Kind_Map || n.Length() != 1 {
+		return nil, false
+	}
+	as, err := traverseKeys(n, selector.SelectorKey_ExploreInterpretAs, selector.SelectorKey_As)
+	if err != nil {
+		return nil, false
+	}
+	if adl, err := as.AsString(); err != nil || adl != "unixfs" {
+		return nil, false
+	}
+	inner, err := traverseKeys(n, selector.SelectorKey_ExploreInterpretAs, selector.SelectorKey_Next)
+	if err != nil || inner.Kind() != datamodel.Kind_Map {
+		return nil, false
+	}
+	return inner, true
+}
+
+func traverseKeys(n datamodel.Node, keys ...string) (datamodel.Node, error) {
+	var err error
+	for _, key := range keys {
+		if n.Kind() == datamodel.Kind_List {
+			n, err = n.LookupBySegment(datamodel.ParsePathSegment(key))
+		} else {
+			n, err = n.LookupByString(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,148 @@
+package trustlessutils_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/stretchr/testify/require"
+)
+
+func parentsSelector(depth int64) datamodel.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	return ssb.ExploreRecursive(selector.RecursionLimitDepth(depth), ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("Parents", ssb.ExploreUnion(ssb.Matcher(), ssb.ExploreAll(ssb.ExploreRecursiveEdge())))
+	})).Node()
+}
+
+func TestSelectorRoundTrip(t *testing.T) {
+	sel := parentsSelector(5)
+
+	encoded, err := trustlessutils.EncodeSelector(sel)
+	require.NoError(t, err)
+	require.NotContains(t, encoded, "=")
+	require.Equal(t, url.QueryEscape(encoded), encoded)
+	decoded, err := trustlessutils.DecodeSelector(encoded)
+	require.NoError(t, err)
+	require.True(t, datamodel.DeepEqual(sel, decoded))
+
+	decoded, err = trustlessutils.DecodeSelector(`{"R":{"l":{"depth":5},":>":{"f":{"f>":{"Parents":{"|":[{".":{}},{"a":{">":{"@":{}}}}]}}}}}}`)
+	require.NoError(t, err)
+	require.True(t, datamodel.DeepEqual(sel, decoded))
+}
+
+func TestCheckSelector(t *testing.T) {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	unlimited := ssb.ExploreRecursive(selector.RecursionLimitNone(), ssb.ExploreAll(ssb.ExploreRecursiveEdge())).Node()
+	nested := ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+		efsb.Insert("a", ssb.ExploreRecursive(selector.RecursionLimitDepth(100), ssb.ExploreAll(ssb.ExploreRecursiveEdge())))
+	}).Node()
+
+	require.NoError(t, trustlessutils.CheckSelector(parentsSelector(5), 5))
+	require.NoError(t, trustlessutils.CheckSelector(ssb.Matcher().Node(), 0))
+	require.ErrorContains(t, trustlessutils.CheckSelector(parentsSelector(6), 5), "depth limit 6 exceeds maximum of 5")
+	require.ErrorContains(t, trustlessutils.CheckSelector(unlimited, 5), "must have a depth limit")
+	require.ErrorContains(t, trustlessutils.CheckSelector(nested, 5), "depth limit 100 exceeds maximum of 5")
+	require.ErrorContains(t, trustlessutils.CheckSelector(basicnode.NewString("nope"), 5), "invalid selector")
+
+	// nested recursion multiplies the reachable depth, siblings don't
+	recursive := func(depth int64, inner builder.SelectorSpec) builder.SelectorSpec {
+		return ssb.ExploreRecursive(selector.RecursionLimitDepth(depth), ssb.ExploreUnion(
+			ssb.ExploreAll(ssb.ExploreRecursiveEdge()),
+			ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) { efsb.Insert("x", inner) }),
+		))
+	}
+	leaf := ssb.ExploreRecursive(selector.RecursionLimitDepth(3), ssb.ExploreAll(ssb.ExploreRecursiveEdge()))
+	nestedRecursion := recursive(3, leaf).Node()
+	doublyNestedRecursion := recursive(2, recursive(2, recursive(2, ssb.Matcher()))).Node()
+	siblings := ssb.ExploreUnion(leaf, leaf).Node()
+
+	require.NoError(t, trustlessutils.CheckSelector(nestedRecursion, 9))
+	require.ErrorContains(t, trustlessutils.CheckSelector(nestedRecursion, 8), "nested recursive explore depth limits reach a depth of more than the maximum of 8")
+	require.NoError(t, trustlessutils.CheckSelector(doublyNestedRecursion, 8))
+	require.ErrorContains(t, trustlessutils.CheckSelector(doublyNestedRecursion, 7), "maximum of 7")
+	require.NoError(t, trustlessutils.CheckSelector(siblings, 3))
+}
+
+func TestRequestCustomSelector(t *testing.T) {
+	sel := parentsSelector(5)
+	request := trustlessutils.Request{Root: testCidV1, CustomSelector: sel}
+
+	require.True(t, datamodel.DeepEqual(sel, request.Selector()))
+
+	urlPath, err := request.UrlPath()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(urlPath, "?dag-scope=all&selector="))
+	u, err := url.Parse(urlPath)
+	require.NoError(t, err)
+	decoded, err := trustlessutils.DecodeSelector(u.Query().Get("selector"))
+	require.NoError(t, err)
+	require.True(t, datamodel.DeepEqual(sel, decoded))
+
+	for _, bad := range []trustlessutils.Request{
+		{Root: testCidV1, CustomSelector: sel, Path: "/nope"},
+		{Root: testCidV1, CustomSelector: sel, Scope: trustlessutils.DagScopeEntity},
+		{Root: testCidV1, CustomSelector: sel, Bytes: &trustlessutils.ByteRange{From: 1}},
+	} {
+		_, err := bad.UrlPath()
+		require.ErrorContains(t, err, "a custom selector cannot be combined")
+	}
+
+	plainEtag := trustlessutils.Request{Root: testCidV1}.Etag("dfs")
+	require.NotEqual(t, plainEtag, trustlessutils.Request{Root: testCidV1, Pathing: trustlessutils.PathingDataModel}.Etag("dfs"))
+	etag := request.Etag("dfs")
+	require.NotEqual(t, plainEtag, etag)
+	require.Equal(t, etag, trustlessutils.Request{Root: testCidV1, CustomSelector: parentsSelector(5)}.Etag("dfs"))
+	require.NotEqual(t, etag, trustlessutils.Request{Root: testCidV1, CustomSelector: parentsSelector(6)}.Etag("dfs"))
+}
+
+func TestRequestFromSelector(t *testing.T) {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+
+	for _, tc := range []struct {
+		name    string
+		request trustlessutils.Request
+	}{
+		{name: "all", request: trustlessutils.Request{Scope: trustlessutils.DagScopeAll}},
+		{name: "entity", request: trustlessutils.Request{Scope: trustlessutils.DagScopeEntity}},
+		{name: "block", request: trustlessutils.Request{Scope: trustlessutils.DagScopeBlock}},
+		{name: "path", request: trustlessutils.Request{Path: "some/path/to/thing", Scope: trustlessutils.DagScopeAll}},
+		{name: "path entity", request: trustlessutils.Request{Path: "some/path", Scope: trustlessutils.DagScopeEntity}},
+		{name: "path block", request: trustlessutils.Request{Path: "some/path", Scope: trustlessutils.DagScopeBlock}},
+		{name: "byte range", request: trustlessutils.Request{Scope: trustlessutils.DagScopeEntity, Bytes: &trustlessutils.ByteRange{From: 100, To: ptr(200)}}},
+		{name: "byte range to end", request: trustlessutils.Request{Scope: trustlessutils.DagScopeEntity, Bytes: &trustlessutils.ByteRange{From: 100}}},
+		{name: "byte range -ve", request: trustlessutils.Request{Path: "a/b", Scope: trustlessutils.DagScopeEntity, Bytes: &trustlessutils.ByteRange{From: -100, To: ptr(-10)}}},
+		{name: "byte range zero", request: trustlessutils.Request{Scope: trustlessutils.DagScopeEntity, Bytes: &trustlessutils.ByteRange{From: 0, To: ptr(0)}}},
+		{name: "datamodel path", request: trustlessutils.Request{Path: "a/0/b", Scope: trustlessutils.DagScopeAll, Pathing: trustlessutils.PathingDataModel}},
+		{name: "datamodel path block", request: trustlessutils.Request{Path: "a/0/b", Scope: trustlessutils.DagScopeBlock, Pathing: trustlessutils.PathingDataModel}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := trustlessutils.RequestFromSelector(tc.request.Selector())
+			require.NoError(t, err)
+			require.Equal(t, tc.request, actual)
+		})
+	}
+
+	for _, tc := range []struct {
+		name     string
+		selector datamodel.Node
+	}{
+		{name: "custom recursion", selector: parentsSelector(5)},
+		{name: "unixfs match", selector: unixfsnode.UnixFSPathSelector("some/path")},
+		{name: "preload", selector: unixfsnode.MatchUnixFSPreloadSelector.Node()},
+		{name: "byte range with all scope", selector: unixfsnode.UnixFSPathSelectorBuilder("a", ssb.ExploreInterpretAs("unixfs", ssb.MatcherSubset(0, 10)), false)},
+		{name: "matching path", selector: unixfsnode.UnixFSPathSelectorBuilder("a/b", unixfsnode.ExploreAllRecursivelySelector, true)},
+		{name: "not a selector", selector: basicnode.NewString("nope")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := trustlessutils.RequestFromSelector(tc.selector)
+			require.ErrorIs(t, err, trustlessutils.ErrSelectorNotExpressible)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package testutil
 
 import (
+	"strings"
 	"testing"
 
 	cid "github.com/ipfs/go-cid"
@@ -39,6 +40,17 @@ var rawlp = cidlink.LinkPrototype{
 // DirEntry is) that has an identity CID in the middle linking a section that
 // the identity CID must be traversed through to find.
 func MakeDagWithIdentity(t *testing.T, lsys linking.LinkSystem) unixfs.DirEntry {
+	return makeDagWithIdentity(t, lsys, 0)
+}
+
+// MakeDagWithLargeIdentity makes the same DAG as MakeDagWithIdentity, except
+// the node inlined in the identity CID is padded so that the identity digest is
+// identitySize bytes long (or its natural size, if that is larger).
+func MakeDagWithLargeIdentity(t *testing.T, lsys linking.LinkSystem, identitySize int) unixfs.DirEntry {
+	return makeDagWithIdentity(t, lsys, identitySize)
+}
+
+func makeDagWithIdentity(t *testing.T, lsys linking.LinkSystem, identitySize int) unixfs.DirEntry {
 	/* ugly, but it makes a DAG with paths that look like this but doesn't involved dag-pb or unixfs
 		> [/]
 	  > [/a/!foo]
@@ -104,10 +116,18 @@ func MakeDagWithIdentity(t *testing.T, lsys linking.LinkSystem) unixfs.DirEntry
 	leaf = store("/a/b/c/d/!leaf", nil, rawlp, basicnode.NewBytes([]byte("leaf node in the root")))
 	foo := store("/a/!foo", nil, djlp, basicnode.NewInt(1010101010101010))
 	bar := store("/a/b/!bar", nil, djlp, basicnode.NewInt(2020202020202020))
-	ident := must(qp.BuildMap(basicnode.Prototype.Any, -1, func(ma datamodel.MapAssembler) {
-		qp.MapEntry(ma, "identity jump", qp.Link(cidlink.Link{Cid: baz.Root}))
-	}))(t)
-	identBytes := must(ipld.Encode(ident, dagjson.Encode))(t)
+	buildIdent := func(padding string) datamodel.Node {
+		return must(qp.BuildMap(basicnode.Prototype.Any, -1, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "identity jump", qp.Link(cidlink.Link{Cid: baz.Root}))
+			if padding != "" {
+				qp.MapEntry(ma, "padding", qp.String(padding))
+			}
+		}))(t)
+	}
+	identBytes := must(ipld.Encode(buildIdent(""), dagjson.Encode))(t)
+	if pad := identitySize - len(identBytes) - len(`,"padding":""`); pad > 0 {
+		identBytes = must(ipld.Encode(buildIdent(strings.Repeat("!", pad)), dagjson.Encode))(t)
+	}
 	mh := must(multihash.Sum(identBytes, multihash.IDENTITY, len(identBytes)))(t)
 	bazident := cid.NewCidV1(cid.DagJSON, mh)
 	bazidentChild := unixfs.DirEntry{
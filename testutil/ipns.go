@@ -0,0 +1,36 @@
+package testutil
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// IpnsKey returns a fixed Ed25519 key, derived from seed, and the IPNS name
+// for that key. The same seed always produces the same key, so tests can use
+// different seeds where distinct names are needed.
+func IpnsKey(t *testing.T, seed byte) (crypto.PrivKey, ipns.Name) {
+	sk, pk, err := crypto.GenerateEd25519Key(bytes.NewReader(bytes.Repeat([]byte{seed}, 32)))
+	require.NoError(t, err)
+	pid, err := peer.IDFromPublicKey(pk)
+	require.NoError(t, err)
+	return sk, ipns.NameFromPeer(pid)
+}
+
+// MakeIpnsRecord returns a serialized IPNS record, signed with sk, pointing
+// to value, which must be a valid /ipfs/ or /ipns/ path.
+func MakeIpnsRecord(t *testing.T, sk crypto.PrivKey, value string, seq uint64, eol time.Time, ttl time.Duration) []byte {
+	p, err := path.NewPath(value)
+	require.NoError(t, err)
+	rec, err := ipns.NewRecord(sk, p, seq, eol, ttl)
+	require.NoError(t, err)
+	byts, err := ipns.MarshalRecord(rec)
+	require.NoError(t, err)
+	return byts
+}
@@ -0,0 +1,238 @@
+package traversal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime/linking"
+	"go.uber.org/multierr"
+)
+
+// Batch is an ordered list of traversals, each described by its own Config,
+// that are expected to be satisfied by a single CAR or BlockStream. The blocks
+// for each traversal are expected to appear in order, as the concatenation of
+// what each Config would verify on its own, with no interleaving between
+// traversals. Duplicate handling applies within each traversal, so a block
+// required by more than one traversal is expected to appear for each of them
+// (subject to each Config's ExpectDuplicatesIn setting).
+type Batch []Config
+
+// Roots returns the Root of each Config in the Batch, in order.
+func (b Batch) Roots() []cid.Cid {
+	roots := make([]cid.Cid, len(b))
+	for i, cfg := range b {
+		roots[i] = cfg.Root
+	}
+	return roots
+}
+
+// VerifyCar reads a CAR from the provided reader and verifies that its blocks
+// are strictly the concatenation of the traversals specified by each Config in
+// the Batch, writing the blocks to the provided LinkSystem. A TraversalResult
+// is returned for each Config, in order.
+//
+// Options that apply to the CAR as a whole are combined across the Batch:
+//
+// * CARv2 is accepted only if every Config sets AllowCARv2, and the CARv2
+// index is verified if any Config sets VerifyCARv2Index.
+//
+// * If any Config sets CheckRootsMismatch, the roots in the CAR header must
+// match the roots of the Batch, in order.
+//
+// * The maximum block size accepted from the CAR is the largest MaxBlockSize
+// in the Batch; each Config's MaxBlockSize is then applied to its own
+// traversal.
+//
+// * The maximum total bytes accepted from the CAR is the sum of MaxTotalBytes
+// across the Batch, or no limit if any Config leaves it unset; each Config's
+// MaxTotalBytes is then applied to its own traversal. Only the Batch limit is
+// checked before block data is allocated.
+//
+// All other options, including MaxDuration and IdleTimeout, apply to each
+// traversal individually.
+//
+// If verification fails, the results for the traversals completed so far are
+// returned along with an error identifying the failing root. If the context is
+// cancelled, the partial progress of the interrupted traversal is also
+// included in the results. A failure after the last traversal, such as an
+// extraneous trailing block, returns the results for every traversal along
+// with an error that doesn't identify a root.
+func (b Batch) VerifyCar(
+	ctx context.Context,
+	rdr io.Reader,
+	lsys linking.LinkSystem,
+) ([]TraversalResult, error) {
+	if len(b) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	allowV2, verifyIndex, checkRoots := true, false, false
+	var maxBlockSize, maxTotalBytes uint64
+	limitTotalBytes := true
+	for _, cfg := range b {
+		allowV2 = allowV2 && cfg.AllowCARv2
+		verifyIndex = verifyIndex || cfg.VerifyCARv2Index
+		checkRoots = checkRoots || cfg.CheckRootsMismatch
+		size := cfg.MaxBlockSize
+		if size == 0 {
+			size = car.DefaultMaxAllowedSectionSize
+		}
+		if size > maxBlockSize {
+			maxBlockSize = size
+		}
+		limitTotalBytes = limitTotalBytes && cfg.MaxTotalBytes > 0
+		maxTotalBytes += cfg.MaxTotalBytes
+	}
+	if !limitTotalBytes {
+		maxTotalBytes = 0
+	}
+
+	interrupt := interruptReader(rdr)
+	if interrupt != nil {
+		stop := context.AfterFunc(ctx, interrupt)
+		defer stop()
+	}
+
+	open := func() (*car.BlockReader, error) {
+		return car.NewBlockReader(rdr, car.WithTrustedCAR(false))
+	}
+	var v2check *carV2Checker
+	if allowV2 && verifyIndex {
+		v2check = &carV2Checker{}
+		open = func() (*car.BlockReader, error) { return v2check.open(rdr) }
+	}
+
+	cbr, err := openBlockReader(ctx, interrupt != nil, open)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, interruptedError(ctx, TraversalResult{})
+		}
+		// TODO: post-1.19: fmt.Errorf("%w: %w", ErrMalformedCar, err)
+		return nil, multierr.Combine(ErrMalformedCar, err)
+	}
+
+	switch cbr.Version {
+	case 1:
+	case 2:
+		if !allowV2 {
+			return nil, ErrBadVersion
+		}
+	default:
+		return nil, ErrBadVersion
+	}
+
+	if checkRoots && !rootsEqual(cbr.Roots, b.Roots()) {
+		return nil, ErrBadRoots
+	}
+	if v2check != nil && !v2check.isV2 {
+		v2check = nil
+	}
+	if v2check != nil {
+		if err := v2check.checkRoots(cbr.Roots); err != nil {
+			return nil, err
+		}
+	}
+
+	brs := &blockReaderStream{
+		cbr:          cbr,
+		blockLimiter: blockLimiter{maxBlockSize: maxBlockSize, maxTotalBytes: maxTotalBytes},
+	}
+	if v2check != nil {
+		brs.onSection = v2check.recordSection
+	}
+	var bs BlockStream = brs
+	if interrupt == nil && ctx.Done() != nil {
+		// a stalled read can't be interrupted by the context on this reader, so
+		// abandon it when needed
+		bs = &timeoutBlockStream{bs: bs}
+	}
+	results, err := b.verifyBlockStream(ctx, bs, lsys, interrupt)
+	if err != nil || v2check == nil {
+		return results, err
+	}
+	if err := v2check.checkIndex(); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// VerifyBlockStream reads blocks from a BlockStream and verifies that they are
+// strictly the concatenation of the traversals specified by each Config in the
+// Batch, writing the blocks to the provided LinkSystem. A TraversalResult is
+// returned for each Config, in order.
+//
+// If verification fails, the results for the traversals completed so far are
+// returned along with an error identifying the failing root. If the context is
+// cancelled, the partial progress of the interrupted traversal is also
+// included in the results. A failure after the last traversal, such as an
+// extraneous trailing block, returns the results for every traversal along
+// with an error that doesn't identify a root.
+func (b Batch) VerifyBlockStream(
+	ctx context.Context,
+	bs BlockStream,
+	lsys linking.LinkSystem,
+) ([]TraversalResult, error) {
+	if len(b) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	return b.verifyBlockStream(ctx, bs, lsys, nil)
+}
+
+func (b Batch) verifyBlockStream(
+	ctx context.Context,
+	bs BlockStream,
+	lsys linking.LinkSystem,
+	interrupt func(),
+) ([]TraversalResult, error) {
+	results := make([]TraversalResult, 0, len(b))
+	for i, cfg := range b {
+		result, err := cfg.traverseSegment(ctx, bs, lsys, interrupt)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, ErrDeadline) {
+				results = append(results, result)
+			}
+			return results, fmt.Errorf("root %d (%s): %w", i, cfg.Root, err)
+		}
+		results = append(results, result)
+	}
+	if _, err := checkStreamEnd(ctx, bs, TraversalResult{}); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// traverseSegment performs a single traversal of a Batch, applying the
+// Config's MaxDuration and IdleTimeout to this traversal only.
+func (cfg Config) traverseSegment(
+	ctx context.Context,
+	bs BlockStream,
+	lsys linking.LinkSystem,
+	interrupt func(),
+) (TraversalResult, error) {
+	start := cfg.clock().Now()
+	if cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.MaxDuration, ErrDeadline)
+		defer cancel()
+	}
+	if cfg.MaxDuration > 0 || cfg.IdleTimeout > 0 {
+		bs = &timeoutBlockStream{bs: bs, idleTimeout: cfg.IdleTimeout, abandon: interrupt}
+	}
+	return cfg.traverseBlockStream(ctx, bs, lsys, start)
+}
+
+func rootsEqual(a, b []cid.Cid) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equals(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+package traversal_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchVerifyCar(t *testing.T) {
+	ctx := context.Background()
+
+	lsys := newStoreLinkSystem()
+
+	tbc1 := trustlesstestutil.SetupBlockChain(ctx, t, lsys, 1000, 20)
+	root1 := tbc1.TipLink.(cidlink.Link).Cid
+	tbc2 := trustlesstestutil.SetupBlockChain(ctx, t, lsys, 1000, 10)
+	root2 := tbc2.TipLink.(cidlink.Link).Cid
+
+	allSelector := selectorparse.CommonSelector_ExploreAllRecursively
+	matchSelector := selectorparse.CommonSelector_MatchPoint
+	allBlocks1 := tbc1.AllBlocks()
+	allBlocks2 := tbc2.AllBlocks()
+	size1 := sizeOf(consumedBlocks(allBlocks1))
+	size2 := sizeOf(consumedBlocks(allBlocks2))
+
+	for _, tc := range []struct {
+		name          string
+		batch         traversal.Batch
+		roots         []cid.Cid
+		blocks        [][]blocks.Block
+		expectErr     error
+		expectPrefix  string
+		expectResults int
+	}{
+		{
+			name: "two full traversals",
+			batch: traversal.Batch{
+				{Root: root1, Selector: allSelector, CheckRootsMismatch: true},
+				{Root: root2, Selector: allSelector},
+			},
+			roots:         []cid.Cid{root1, root2},
+			blocks:        [][]blocks.Block{allBlocks1, allBlocks2},
+			expectResults: 2,
+		},
+		{
+			name: "mixed selectors",
+			batch: traversal.Batch{
+				{Root: root1, Selector: matchSelector},
+				{Root: root2, Selector: allSelector},
+			},
+			roots:         []cid.Cid{root1, root2},
+			blocks:        [][]blocks.Block{allBlocks1[:1], allBlocks2},
+			expectResults: 2,
+		},
+		{
+			name: "wrong order",
+			batch: traversal.Batch{
+				{Root: root1, Selector: allSelector},
+				{Root: root2, Selector: allSelector},
+			},
+			roots:        []cid.Cid{root1, root2},
+			blocks:       [][]blocks.Block{allBlocks2, allBlocks1},
+			expectErr:    traversal.ErrUnexpectedBlock,
+			expectPrefix: "root 0 (" + root1.String() + "): ",
+		},
+		{
+			name: "roots mismatch",
+			batch: traversal.Batch{
+				{Root: root1, Selector: allSelector, CheckRootsMismatch: true},
+				{Root: root2, Selector: allSelector},
+			},
+			roots:     []cid.Cid{root2, root1},
+			blocks:    [][]blocks.Block{allBlocks1, allBlocks2},
+			expectErr: traversal.ErrBadRoots,
+		},
+		{
+			name: "missing blocks in first traversal",
+			batch: traversal.Batch{
+				{Root: root1, Selector: allSelector},
+				{Root: root2, Selector: allSelector},
+			},
+			roots:        []cid.Cid{root1, root2},
+			blocks:       [][]blocks.Block{allBlocks1[:10], allBlocks2},
+			expectErr:    traversal.ErrUnexpectedBlock,
+			expectPrefix: "root 0 (" + root1.String() + "): ",
+		},
+		{
+			name: "missing blocks in last traversal",
+			batch: traversal.Batch{
+				{Root: root1, Selector: allSelector},
+				{Root: root2, Selector: allSelector},
+			},
+			roots:         []cid.Cid{root1, root2},
+			blocks:        [][]blocks.Block{allBlocks1, allBlocks2[:5]},
+			expectErr:     traversal.ErrMissingBlock,
+			expectPrefix:  "root 1 (" + root2.String() + "): ",
+			expectResults: 1,
+		},
+		{
+			name: "extraneous trailing block",
+			batch: traversal.Batch{
+				{Root: root1, Selector: matchSelector},
+				{Root: root2, Selector: matchSelector},
+			},
+			roots: []cid.Cid{root1, root2},
+			// the trailing group isn't part of either traversal
+			blocks:        [][]blocks.Block{allBlocks1[:1], allBlocks2[:1], allBlocks2[1:2]},
+			expectErr:     traversal.ErrExtraneousBlock,
+			expectResults: 2,
+		},
+		{
+			name: "max total bytes",
+			batch: traversal.Batch{
+				{Root: root1, Selector: allSelector, MaxTotalBytes: size1},
+				{Root: root2, Selector: allSelector, MaxTotalBytes: size2},
+			},
+			roots:         []cid.Cid{root1, root2},
+			blocks:        [][]blocks.Block{allBlocks1, allBlocks2},
+			expectResults: 2,
+		},
+		{
+			name: "max total bytes exceeded",
+			batch: traversal.Batch{
+				{Root: root1, Selector: allSelector, MaxTotalBytes: size1},
+				{Root: root2, Selector: allSelector, MaxTotalBytes: size2 - 1},
+			},
+			roots:         []cid.Cid{root1, root2},
+			blocks:        [][]blocks.Block{allBlocks1, allBlocks2},
+			expectErr:     traversal.ErrTooManyBytes,
+			expectPrefix:  "root 1 (" + root2.String() + "): ",
+			expectResults: 1,
+		},
+		{
+			name:      "empty batch",
+			roots:     []cid.Cid{root1},
+			blocks:    [][]blocks.Block{allBlocks1},
+			expectErr: traversal.ErrEmptyBatch,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			defer cancel()
+
+			var expected []expectedBlock
+			for _, blks := range tc.blocks {
+				expected = append(expected, consumedBlocks(blks)...)
+			}
+			carStream, errorCh := makeCarStream(t, ctx, tc.roots, expected, false, tc.expectErr != nil, true, nil, false, false)
+
+			results, err := tc.batch.VerifyCar(ctx, carStream, newOutputLinkSystem())
+			if tc.expectErr != nil {
+				req.ErrorIs(err, tc.expectErr)
+				req.True(strings.HasPrefix(err.Error(), tc.expectPrefix), err.Error())
+			} else {
+				req.NoError(err)
+			}
+			req.Len(results, tc.expectResults)
+			for i, result := range results {
+				blks := tc.blocks[i]
+				req.Equal(uint64(len(blks)), result.BlocksIn)
+				req.Equal(sizeOf(consumedBlocks(blks)), result.BytesIn)
+				req.Equal(uint64(len(blks)), result.BlocksOut)
+			}
+
+			if tc.expectErr == nil {
+				select {
+				case err := <-errorCh:
+					req.NoError(err)
+				default:
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package traversal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-varint"
+)
+
+// NewChannelBlockStream returns a BlockStream that reads blocks from the
+// provided channel, such as blocks arriving asynchronously from Bitswap or
+// Graphsync. Closing the channel signals the end of the stream, resulting in
+// an io.EOF from Next. Next will return early with the context's error if the
+// context is cancelled while waiting for a block.
+func NewChannelBlockStream(ch <-chan blocks.Block) BlockStream {
+	return &channelBlockStream{ch: ch}
+}
+
+type channelBlockStream struct {
+	ch <-chan blocks.Block
+}
+
+func (cbs *channelBlockStream) Next(ctx context.Context) (blocks.Block, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case blk, ok := <-cbs.ch:
+		if !ok {
+			return nil, io.EOF
+		}
+		return blk, nil
+	}
+}
+
+// NewLengthPrefixedBlockStream returns a BlockStream that reads blocks from
+// the provided reader, where each block is encoded in the same form as a CAR
+// section without a CAR header: an unsigned varint length prefix, followed by
+// the CID and the block data.
+//
+// Each block is verified against its CID as it is read. If maxBlockSize is
+// greater than zero, blocks with data larger than maxBlockSize will be rejected
+// with ErrBlockTooLarge before their data is read, otherwise the go-car section
+// limit (8 MiB) is used.
+//
+// The end of the reader signals the end of the stream, resulting in an io.EOF
+// from Next. The context is checked before each block is read, but a read that
+// is blocked on the underlying reader can only be interrupted by closing it.
+func NewLengthPrefixedBlockStream(r io.Reader, maxBlockSize uint64) BlockStream {
+	if maxBlockSize == 0 {
+		maxBlockSize = car.DefaultMaxAllowedSectionSize
+	}
+	return &lengthPrefixedBlockStream{
+		r:            bufio.NewReader(r),
+		blockLimiter: blockLimiter{maxBlockSize: maxBlockSize},
+	}
+}
+
+type lengthPrefixedBlockStream struct {
+	r *bufio.Reader
+	blockLimiter
+}
+
+func (lbs *lengthPrefixedBlockStream) Next(ctx context.Context) (blocks.Block, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sectionLength, err := varint.ReadUvarint(lbs.r)
+	if err != nil {
+		return nil, err
+	}
+	if sectionLength == 0 {
+		return nil, errors.New("invalid zero-length block section")
+	}
+	n, c, err := cid.CidFromReader(io.LimitReader(lbs.r, int64(min(sectionLength, math.MaxInt64))))
+	if err != nil {
+		return nil, err
+	}
+	return lbs.readBlock(c, lbs.r, sectionLength-uint64(n))
+}
+
+// BlockStreamFunc adapts a function that pulls the next block from some source
+// into a BlockStream. The function should return io.EOF at the end of the
+// stream and should respect cancellation of the provided context; Next will
+// not call the function if the context has already been cancelled.
+type BlockStreamFunc func(ctx context.Context) (blocks.Block, error)
+
+func (bsf BlockStreamFunc) Next(ctx context.Context) (blocks.Block, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return bsf(ctx)
+}
+
+// checkBlockLimits checks a block of the given size against the maximum block
+// size and, combined with the total bytes already read, the maximum total bytes.
+// A zero maximum is treated as no limit.
+func checkBlockLimits(size, totalBytes, maxBlockSize, maxTotalBytes uint64) error {
+	if maxBlockSize > 0 && size > maxBlockSize {
+		return fmt.Errorf("%w: %d > %d", ErrBlockTooLarge, size, maxBlockSize)
+	}
+	if maxTotalBytes > 0 && totalBytes+size > maxTotalBytes {
+		return fmt.Errorf("%w: %d > %d", ErrTooManyBytes, totalBytes+size, maxTotalBytes)
+	}
+	return nil
+}
+
+// blockLimiter reads block data of a known length, having first checked the
+// length against the configured limits so that oversized blocks are rejected
+// before any block data is allocated.
+type blockLimiter struct {
+	maxBlockSize  uint64
+	maxTotalBytes uint64
+	totalBytes    uint64
+}
+
+func (bl *blockLimiter) readBlock(c cid.Cid, rdr io.Reader, length uint64) (blocks.Block, error) {
+	if err := checkBlockLimits(length, bl.totalBytes, bl.maxBlockSize, bl.maxTotalBytes); err != nil {
+		return nil, err
+	}
+	bl.totalBytes += length
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(rdr, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	hashed, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !hashed.Equals(c) {
+		return nil, fmt.Errorf("mismatch in content integrity, expected: %s, got: %s", c, hashed)
+	}
+
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// blockReaderStream is a BlockStream that reads blocks from a car.BlockReader.
+// Section headers are read first so that block sizes can be checked against
+// the configured limits before any block data is allocated.
+type blockReaderStream struct {
+	cbr       *car.BlockReader
+	onSection func(cid.Cid, uint64) // called with the CID and data length after each section header is read
+	blockLimiter
+}
+
+func (brs *blockReaderStream) Next(ctx context.Context) (blocks.Block, error) {
+	c, rdr, length, err := brs.cbr.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	if brs.onSection != nil {
+		brs.onSection(c, length)
+	}
+	// NextReader doesn't verify the block data, readBlock does it for us
+	return brs.readBlock(c, rdr, length)
+}
+
+// timeoutBlockStream wraps a BlockStream so that calls to Next are abandoned
+// if the context is cancelled or no block arrives within the idle timeout.
+// Once a call has been abandoned the underlying BlockStream may still be in
+// use, so all subsequent calls return the same error. The optional abandon
+// function is called when a call is abandoned, to interrupt the underlying
+// BlockStream where possible.
+type timeoutBlockStream struct {
+	bs          BlockStream
+	idleTimeout time.Duration
+	abandon     func()
+	err         error
+}
+
+type nextResult struct {
+	blk blocks.Block
+	err error
+}
+
+func (tbs *timeoutBlockStream) Next(ctx context.Context) (blocks.Block, error) {
+	if tbs.err != nil {
+		return nil, tbs.err
+	}
+
+	// the call gets its own context so that an abandoned call stops waiting on
+	// the underlying BlockStream rather than consuming a block that arrives later
+	nextCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := make(chan nextResult, 1)
+	go func() {
+		blk, err := tbs.bs.Next(nextCtx)
+		ch <- nextResult{blk, err}
+	}()
+
+	var idle <-chan time.Time
+	if tbs.idleTimeout > 0 {
+		timer := time.NewTimer(tbs.idleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
+	select {
+	case res := <-ch:
+		return res.blk, res.err
+	case <-idle:
+		tbs.err = fmt.Errorf("%w: no block received within %s", ErrIdleTimeout, tbs.idleTimeout)
+	case <-ctx.Done():
+		tbs.err = context.Cause(ctx)
+	}
+	if tbs.abandon != nil {
+		tbs.abandon()
+	}
+	return nil, tbs.err
+}
@@ -0,0 +1,203 @@
+package traversal_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/ipld/go-trustless-utils/traversal"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockStreamAdapters(t *testing.T) {
+	ctx := context.Background()
+
+	lsys := newStoreLinkSystem()
+	tbc := trustlesstestutil.SetupBlockChain(ctx, t, lsys, 1000, 20)
+	root := tbc.TipLink.(cidlink.Link).Cid
+	allBlocks := tbc.AllBlocks()
+
+	for _, tc := range []struct {
+		name   string
+		stream func([]blocks.Block) traversal.BlockStream
+	}{
+		{
+			name: "channel",
+			stream: func(blks []blocks.Block) traversal.BlockStream {
+				ch := make(chan blocks.Block)
+				go func() {
+					defer close(ch)
+					for _, blk := range blks {
+						ch <- blk
+					}
+				}()
+				return traversal.NewChannelBlockStream(ch)
+			},
+		},
+		{
+			name: "length prefixed",
+			stream: func(blks []blocks.Block) traversal.BlockStream {
+				return traversal.NewLengthPrefixedBlockStream(bytes.NewReader(lengthPrefixed(blks...)), 0)
+			},
+		},
+		{
+			name: "func",
+			stream: func(blks []blocks.Block) traversal.BlockStream {
+				return traversal.BlockStreamFunc(func(ctx context.Context) (blocks.Block, error) {
+					if len(blks) == 0 {
+						return nil, io.EOF
+					}
+					blk := blks[0]
+					blks = blks[1:]
+					return blk, nil
+				})
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Run("complete", func(t *testing.T) {
+				req := require.New(t)
+				cfg := traversal.Config{Root: root, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
+				result, err := cfg.VerifyBlockStream(ctx, tc.stream(allBlocks), newOutputLinkSystem())
+				req.NoError(err)
+				req.Equal(uint64(len(allBlocks)), result.BlocksIn)
+				req.Equal(uint64(len(allBlocks)), result.BlocksOut)
+			})
+
+			t.Run("missing block", func(t *testing.T) {
+				cfg := traversal.Config{Root: root, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
+				_, err := cfg.VerifyBlockStream(ctx, tc.stream(allBlocks[:10]), newOutputLinkSystem())
+				require.ErrorIs(t, err, traversal.ErrMissingBlock)
+			})
+
+			t.Run("extraneous block", func(t *testing.T) {
+				cfg := traversal.Config{Root: root, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
+				_, err := cfg.VerifyBlockStream(ctx, tc.stream(append(append([]blocks.Block{}, allBlocks...), allBlocks[0])), newOutputLinkSystem())
+				require.ErrorIs(t, err, traversal.ErrExtraneousBlock)
+			})
+		})
+	}
+}
+
+func TestChannelBlockStreamCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	bs := traversal.NewChannelBlockStream(make(chan blocks.Block))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := bs.Next(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestChannelBlockStreamIdleTimeout(t *testing.T) {
+	// a Next call abandoned after the idle timeout must not go on to consume a
+	// block sent on the channel afterwards
+	data := []byte("this is a block")
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}.Sum(data)
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(data, c)
+	require.NoError(t, err)
+
+	ch := make(chan blocks.Block)
+	cfg := traversal.Config{
+		Root:        c,
+		Selector:    selectorparse.CommonSelector_ExploreAllRecursively,
+		IdleTimeout: 10 * time.Millisecond,
+	}
+	_, err = cfg.VerifyBlockStream(context.Background(), traversal.NewChannelBlockStream(ch), newOutputLinkSystem())
+	require.ErrorIs(t, err, traversal.ErrIdleTimeout)
+
+	select {
+	case ch <- blk:
+		t.Fatal("block consumed after the stream was abandoned")
+	default:
+	}
+}
+
+func TestBlockStreamFuncCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bs := traversal.BlockStreamFunc(func(ctx context.Context) (blocks.Block, error) {
+		t.Fatal("unexpected call")
+		return nil, nil
+	})
+	_, err := bs.Next(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestLengthPrefixedBlockStream(t *testing.T) {
+	ctx := context.Background()
+	data := []byte("this is a block")
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}.Sum(data)
+	require.NoError(t, err)
+	blk, err := blocks.NewBlockWithCid(data, c)
+	require.NoError(t, err)
+	valid := lengthPrefixed(blk)
+
+	t.Run("valid", func(t *testing.T) {
+		bs := traversal.NewLengthPrefixedBlockStream(bytes.NewReader(valid), 0)
+		got, err := bs.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, c, got.Cid())
+		require.Equal(t, data, got.RawData())
+		_, err = bs.Next(ctx)
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		bs := traversal.NewLengthPrefixedBlockStream(bytes.NewReader(valid[:len(valid)-1]), 0)
+		_, err := bs.Next(ctx)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		corrupted := append([]byte{}, valid...)
+		corrupted[len(corrupted)-1] ^= 0xff
+		bs := traversal.NewLengthPrefixedBlockStream(bytes.NewReader(corrupted), 0)
+		_, err := bs.Next(ctx)
+		require.ErrorContains(t, err, "mismatch in content integrity")
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		bs := traversal.NewLengthPrefixedBlockStream(bytes.NewReader(valid), uint64(len(data)-1))
+		_, err := bs.Next(ctx)
+		require.ErrorIs(t, err, traversal.ErrBlockTooLarge)
+	})
+
+	t.Run("oversized header", func(t *testing.T) {
+		var buf bytes.Buffer
+		buf.Write(varint.ToUvarint(uint64(c.ByteLen()) + 4<<30))
+		buf.Write(c.Bytes())
+		bs := traversal.NewLengthPrefixedBlockStream(&buf, 0)
+		_, err := bs.Next(ctx)
+		require.ErrorIs(t, err, traversal.ErrBlockTooLarge)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		bs := traversal.NewLengthPrefixedBlockStream(bytes.NewReader(valid), 0)
+		_, err := bs.Next(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func lengthPrefixed(blks ...blocks.Block) []byte {
+	var buf bytes.Buffer
+	for _, blk := range blks {
+		buf.Write(varint.ToUvarint(uint64(blk.Cid().ByteLen() + len(blk.RawData()))))
+		buf.Write(blk.Cid().Bytes())
+		buf.Write(blk.RawData())
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,177 @@
+package traversal_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCarCancellation(t *testing.T) {
+	ctx := context.Background()
+
+	lsys := newStoreLinkSystem()
+	tbc := trustlesstestutil.SetupBlockChain(ctx, t, lsys, 1000, 20)
+	root := tbc.TipLink.(cidlink.Link).Cid
+
+	var buf bytes.Buffer
+	carWriter, err := storage.NewWritable(&buf, []cid.Cid{root}, car.WriteAsCarV1(true))
+	require.NoError(t, err)
+	headerLen := buf.Len()
+	for _, blk := range tbc.AllBlocks()[:5] {
+		require.NoError(t, carWriter.Put(ctx, blk.Cid().KeyString(), blk.RawData()))
+	}
+	partialCar := buf.Bytes()
+
+	for _, tc := range []struct {
+		name           string
+		data           []byte
+		reader         func(*blockingReader) io.Reader
+		cfg            traversal.Config
+		expectErr      error
+		expectBlocksIn uint64
+	}{
+		{
+			name:           "plain reader",
+			data:           partialCar,
+			reader:         func(br *blockingReader) io.Reader { return br },
+			expectErr:      context.Canceled,
+			expectBlocksIn: 5,
+		},
+		{
+			name:           "closing reader",
+			data:           partialCar,
+			reader:         func(br *blockingReader) io.Reader { return closingReader{br} },
+			expectErr:      context.Canceled,
+			expectBlocksIn: 5,
+		},
+		{
+			name:           "deadline reader",
+			data:           partialCar,
+			reader:         func(br *blockingReader) io.Reader { return deadlineReader{br} },
+			expectErr:      context.Canceled,
+			expectBlocksIn: 5,
+		},
+		{
+			name:      "plain reader, stalled in header",
+			data:      partialCar[:headerLen-1],
+			reader:    func(br *blockingReader) io.Reader { return br },
+			expectErr: context.Canceled,
+		},
+		{
+			name:      "closing reader, stalled in header",
+			data:      partialCar[:headerLen-1],
+			reader:    func(br *blockingReader) io.Reader { return closingReader{br} },
+			expectErr: context.Canceled,
+		},
+		{
+			name:           "closing reader, deadline",
+			data:           partialCar,
+			reader:         func(br *blockingReader) io.Reader { return closingReader{br} },
+			cfg:            traversal.Config{MaxDuration: 500 * time.Millisecond},
+			expectErr:      traversal.ErrDeadline,
+			expectBlocksIn: 5,
+		},
+		{
+			name:      "closing reader, idle timeout",
+			data:      partialCar,
+			reader:    func(br *blockingReader) io.Reader { return closingReader{br} },
+			cfg:       traversal.Config{IdleTimeout: 500 * time.Millisecond},
+			expectErr: traversal.ErrIdleTimeout,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			br := newBlockingReader(tc.data)
+			t.Cleanup(br.unblock)
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			if tc.cfg.MaxDuration == 0 && tc.cfg.IdleTimeout == 0 {
+				// cancel once the reader has run dry and is blocking
+				go func() {
+					<-br.stalled
+					cancel()
+				}()
+			}
+
+			cfg := tc.cfg
+			cfg.Root = root
+			cfg.Selector = selectorparse.CommonSelector_ExploreAllRecursively
+
+			start := time.Now()
+			result, err := cfg.VerifyCar(ctx, tc.reader(br), newOutputLinkSystem())
+			req.Less(time.Since(start), 5*time.Second)
+			req.ErrorIs(err, tc.expectErr)
+			req.Equal(tc.expectBlocksIn, result.BlocksIn)
+			if tc.expectBlocksIn > 0 {
+				req.ErrorContains(err, "verification interrupted after 5 blocks")
+			}
+		})
+	}
+}
+
+// blockingReader reads the provided data and then blocks until unblocked, at
+// which point it returns an error, as a stalled network read would. stalled is
+// closed the first time a read blocks.
+type blockingReader struct {
+	r           *bytes.Reader
+	once        sync.Once
+	stalledOnce sync.Once
+	blocked     chan struct{}
+	stalled     chan struct{}
+}
+
+func newBlockingReader(data []byte) *blockingReader {
+	return &blockingReader{
+		r:       bytes.NewReader(data),
+		blocked: make(chan struct{}),
+		stalled: make(chan struct{}),
+	}
+}
+
+func (br *blockingReader) Read(p []byte) (int, error) {
+	if br.r.Len() > 0 {
+		return br.r.Read(p)
+	}
+	br.stalledOnce.Do(func() { close(br.stalled) })
+	<-br.blocked
+	return 0, errors.New("read interrupted")
+}
+
+func (br *blockingReader) unblock() {
+	br.once.Do(func() { close(br.blocked) })
+}
+
+type closingReader struct {
+	*blockingReader
+}
+
+func (cr closingReader) Close() error {
+	cr.unblock()
+	return nil
+}
+
+type deadlineReader struct {
+	*blockingReader
+}
+
+func (dr deadlineReader) SetReadDeadline(t time.Time) error {
+	if time.Now().After(t) {
+		dr.unblock()
+	}
+	return nil
+}
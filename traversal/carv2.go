@@ -0,0 +1,254 @@
+package traversal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"go.uber.org/multierr"
+)
+
+// VerifyCarToCarV2File performs VerifyCar on the provided reader, writing the
+// verified blocks to an indexed CARv2 file at the given path with the Config's
+// Root as its single root.
+//
+// Blocks are written to a temporary file in the same directory as path, which
+// is only finalised (writing the CARv2 header and index) and renamed to path if
+// verification succeeds. On failure the temporary file is removed and nothing
+// is written to path; an existing file at path is left untouched.
+func (cfg Config) VerifyCarToCarV2File(ctx context.Context, rdr io.Reader, path string) (TraversalResult, error) {
+	return cfg.verifyToCarV2File(path, func(lsys linking.LinkSystem) (TraversalResult, error) {
+		return cfg.VerifyCar(ctx, rdr, lsys)
+	})
+}
+
+// VerifyBlockStreamToCarV2File performs VerifyBlockStream on the provided
+// BlockStream, writing the verified blocks to an indexed CARv2 file at the given
+// path in the same way as VerifyCarToCarV2File.
+func (cfg Config) VerifyBlockStreamToCarV2File(ctx context.Context, bs BlockStream, path string) (TraversalResult, error) {
+	return cfg.verifyToCarV2File(path, func(lsys linking.LinkSystem) (TraversalResult, error) {
+		return cfg.VerifyBlockStream(ctx, bs, lsys)
+	})
+}
+
+func (cfg Config) verifyToCarV2File(
+	path string,
+	verify func(linking.LinkSystem) (TraversalResult, error),
+) (TraversalResult, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return TraversalResult{}, err
+	}
+	var committed bool
+	defer func() {
+		if !committed {
+			// errors are ignored, the file may already be closed
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	carStore, err := storage.NewReadableWritable(f, []cid.Cid{cfg.Root}, car.AllowDuplicatePuts(cfg.WriteDuplicatesOut))
+	if err != nil {
+		return TraversalResult{}, err
+	}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(carStore)
+	lsys.SetWriteStorage(carStore)
+
+	result, err := verify(lsys)
+	if err != nil {
+		return result, err
+	}
+	if err := carStore.Finalize(); err != nil {
+		return TraversalResult{}, err
+	}
+	if err := f.Close(); err != nil {
+		return TraversalResult{}, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return TraversalResult{}, err
+	}
+	committed = true
+	return result, nil
+}
+
+// carV2Checker reads a CARv2 stream on behalf of a car.BlockReader, keeping
+// track of the position of each block section in the data payload so that the
+// embedded index can be checked against them once the data payload has been
+// verified.
+type carV2Checker struct {
+	cr          *countingReader
+	pragmaRoots []cid.Cid
+	header      car.Header
+	isV2        bool
+	sections    map[string][]uint64 // multihash -> offsets within the data payload
+}
+
+// open reads the pragma, and CARv2 header if present, from the reader before
+// handing the stream to a car.BlockReader.
+func (cc *carV2Checker) open(rdr io.Reader) (*car.BlockReader, error) {
+	br := bufio.NewReader(rdr)
+	var prelude bytes.Buffer
+	tr := io.TeeReader(br, &prelude)
+
+	pragmaLen, err := varint.ReadUvarint(byteReader{tr})
+	if err != nil {
+		return nil, err
+	}
+	if pragmaLen > car.DefaultMaxAllowedHeaderSize {
+		return nil, errors.New("invalid header data, length of read beyond allowable maximum")
+	}
+	pragma := basicnode.Prototype.Map.NewBuilder()
+	if err := dagcbor.Decode(pragma, io.LimitReader(tr, int64(pragmaLen))); err != nil {
+		return nil, err
+	}
+	version, err := pragma.Build().LookupByString("version")
+	if err != nil {
+		return nil, err
+	}
+	if v, err := version.AsInt(); err == nil && v == 2 {
+		cc.isV2 = true
+		if roots, err := pragma.Build().LookupByString("roots"); err == nil {
+			iter := roots.ListIterator()
+			for iter != nil && !iter.Done() {
+				_, rn, err := iter.Next()
+				if err != nil {
+					return nil, err
+				}
+				lnk, err := rn.AsLink()
+				if err != nil {
+					return nil, err
+				}
+				cc.pragmaRoots = append(cc.pragmaRoots, lnk.(cidlink.Link).Cid)
+			}
+		}
+		if _, err := cc.header.ReadFrom(tr); err != nil {
+			return nil, err
+		}
+	}
+
+	cc.sections = make(map[string][]uint64)
+	cc.cr = &countingReader{r: io.MultiReader(&prelude, br)}
+	return car.NewBlockReader(cc.cr, car.WithTrustedCAR(false))
+}
+
+// checkRoots checks that any roots declared in the CARv2 pragma match the roots
+// of the inner CARv1 payload.
+func (cc *carV2Checker) checkRoots(roots []cid.Cid) error {
+	if len(cc.pragmaRoots) == 0 {
+		return nil
+	}
+	if len(cc.pragmaRoots) != len(roots) {
+		return fmt.Errorf("%w: CARv2 pragma roots differ from data payload roots", ErrBadRoots)
+	}
+	for i, r := range cc.pragmaRoots {
+		if !r.Equals(roots[i]) {
+			return fmt.Errorf("%w: CARv2 pragma roots differ from data payload roots", ErrBadRoots)
+		}
+	}
+	return nil
+}
+
+// recordSection records the position of a block section within the data
+// payload, it should be called immediately after the section header (the length
+// prefix and CID) has been read from the car.BlockReader.
+func (cc *carV2Checker) recordSection(c cid.Cid, length uint64) {
+	cidLen := uint64(c.ByteLen())
+	headerLen := uint64(varint.UvarintSize(cidLen+length)) + cidLen
+	mh := string(c.Hash())
+	cc.sections[mh] = append(cc.sections[mh], cc.cr.n-headerLen-cc.header.DataOffset)
+}
+
+// checkIndex reads the index, if one is present, following the data payload
+// and checks that every indexed block is present in the data payload at the
+// indexed offset, and that every block in the data payload is indexed.
+func (cc *carV2Checker) checkIndex() error {
+	if !cc.header.HasIndex() {
+		return nil
+	}
+	if cc.header.IndexOffset < cc.cr.n {
+		return fmt.Errorf("%w: index offset %d overlaps data payload", ErrBadIndex, cc.header.IndexOffset)
+	}
+	if _, err := io.CopyN(io.Discard, cc.cr, int64(cc.header.IndexOffset-cc.cr.n)); err != nil {
+		return multierr.Combine(ErrBadIndex, err)
+	}
+	idx, err := index.ReadFrom(cc.cr)
+	if err != nil {
+		return multierr.Combine(ErrBadIndex, err)
+	}
+
+	for mh, offsets := range cc.sections {
+		var found int
+		var mismatch error
+		err := idx.GetAll(cid.NewCidV1(cid.Raw, multihash.Multihash(mh)), func(offset uint64) bool {
+			found++
+			if !slices.Contains(offsets, offset) {
+				mismatch = fmt.Errorf("%w: %s indexed at offset %d which is not a section for that block", ErrBadIndex, multihash.Multihash(mh).B58String(), offset)
+				return false
+			}
+			return true
+		})
+		if mismatch != nil {
+			return mismatch
+		}
+		if err != nil {
+			if errors.Is(err, index.ErrNotFound) {
+				return fmt.Errorf("%w: %s is not indexed", ErrBadIndex, multihash.Multihash(mh).B58String())
+			}
+			return multierr.Combine(ErrBadIndex, err)
+		}
+		if found == 0 {
+			return fmt.Errorf("%w: %s is not indexed", ErrBadIndex, multihash.Multihash(mh).B58String())
+		}
+	}
+
+	if iidx, ok := idx.(index.IterableIndex); ok {
+		if err := iidx.ForEach(func(mh multihash.Multihash, offset uint64) error {
+			if !slices.Contains(cc.sections[string(mh)], offset) {
+				return fmt.Errorf("%w: %s indexed at offset %d which is not in the data payload", ErrBadIndex, mh.B58String(), offset)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n)
+	return n, err
+}
+
+type byteReader struct {
+	io.Reader
+}
+
+func (br byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(br, b[:])
+	return b[0], err
+}
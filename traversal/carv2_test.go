@@ -0,0 +1,303 @@
+package traversal_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/multiformats/go-multicodec"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCarToCarV2File(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	lsys := newStoreLinkSystem()
+	tbc := trustlesstestutil.SetupBlockChain(ctx, t, lsys, 1000, 20)
+	root := tbc.TipLink.(cidlink.Link).Cid
+	allBlocks := tbc.AllBlocks()
+
+	cfg := traversal.Config{
+		Root:     root,
+		Selector: selectorparse.CommonSelector_ExploreAllRecursively,
+	}
+
+	t.Run("success", func(t *testing.T) {
+		req := require.New(t)
+		dir := t.TempDir()
+		path := filepath.Join(dir, "out.car")
+
+		carStream, errorCh := makeCarStream(t, ctx, []cid.Cid{root}, consumedBlocks(allBlocks), false, false, false, nil, false, false)
+		result, err := cfg.VerifyCarToCarV2File(ctx, carStream, path)
+		req.NoError(err)
+		req.Equal(uint64(len(allBlocks)), result.BlocksOut)
+		select {
+		case err := <-errorCh:
+			req.NoError(err)
+		default:
+		}
+
+		// only the final file should remain
+		entries, err := os.ReadDir(dir)
+		req.NoError(err)
+		req.Len(entries, 1)
+
+		cr, err := car.OpenReader(path)
+		req.NoError(err)
+		defer cr.Close()
+		req.Equal(uint64(2), cr.Version)
+		roots, err := cr.Roots()
+		req.NoError(err)
+		req.Equal([]cid.Cid{root}, roots)
+		req.True(cr.Header.HasIndex())
+
+		f, err := os.Open(path)
+		req.NoError(err)
+		defer f.Close()
+		rc, err := storage.OpenReadable(f)
+		req.NoError(err)
+		for _, blk := range allBlocks {
+			data, err := rc.Get(ctx, blk.Cid().KeyString())
+			req.NoError(err)
+			req.Equal(blk.RawData(), data)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		req := require.New(t)
+		dir := t.TempDir()
+		path := filepath.Join(dir, "out.car")
+
+		// missing the trailing blocks
+		carStream, _ := makeCarStream(t, ctx, []cid.Cid{root}, consumedBlocks(allBlocks[:10]), false, false, false, nil, false, false)
+		_, err := cfg.VerifyCarToCarV2File(ctx, carStream, path)
+		req.ErrorIs(err, traversal.ErrMissingBlock)
+
+		entries, err := os.ReadDir(dir)
+		req.NoError(err)
+		req.Len(entries, 0)
+	})
+
+	t.Run("failure leaves existing file", func(t *testing.T) {
+		req := require.New(t)
+		dir := t.TempDir()
+		path := filepath.Join(dir, "out.car")
+		req.NoError(os.WriteFile(path, []byte("existing"), 0o644))
+
+		carStream, _ := makeCarStream(t, ctx, []cid.Cid{root}, consumedBlocks(allBlocks[:10]), false, false, false, nil, false, false)
+		_, err := cfg.VerifyCarToCarV2File(ctx, carStream, path)
+		req.ErrorIs(err, traversal.ErrMissingBlock)
+
+		entries, err := os.ReadDir(dir)
+		req.NoError(err)
+		req.Len(entries, 1)
+		existing, err := os.ReadFile(path)
+		req.NoError(err)
+		req.Equal([]byte("existing"), existing)
+	})
+
+	t.Run("block stream", func(t *testing.T) {
+		req := require.New(t)
+		path := filepath.Join(t.TempDir(), "out.car")
+		bs := traversal.NewLengthPrefixedBlockStream(bytes.NewReader(lengthPrefixed(allBlocks...)), 0)
+		result, err := cfg.VerifyBlockStreamToCarV2File(ctx, bs, path)
+		req.NoError(err)
+		req.Equal(uint64(len(allBlocks)), result.BlocksIn)
+		_, err = os.Stat(path)
+		req.NoError(err)
+	})
+}
+
+func TestVerifyCARv2Index(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	lsys := newStoreLinkSystem()
+	tbc := trustlesstestutil.SetupBlockChain(ctx, t, lsys, 1000, 20)
+	root := tbc.TipLink.(cidlink.Link).Cid
+	allBlocks := tbc.AllBlocks()
+
+	// build the CARv1 data payload and collect index records for it
+	var v1 bytes.Buffer
+	carWriter, err := storage.NewWritable(&v1, []cid.Cid{root}, car.WriteAsCarV1(true))
+	require.NoError(t, err)
+	for _, blk := range allBlocks {
+		require.NoError(t, carWriter.Put(ctx, blk.Cid().KeyString(), blk.RawData()))
+	}
+	require.NoError(t, carWriter.Finalize())
+	cbr, err := car.NewBlockReader(bytes.NewReader(v1.Bytes()))
+	require.NoError(t, err)
+	var records []index.Record
+	for {
+		md, err := cbr.SkipNext()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		records = append(records, index.Record{Cid: md.Cid, Offset: md.Offset})
+	}
+	require.Len(t, records, len(allBlocks))
+
+	extraneousCid, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}.Sum([]byte("nope"))
+	require.NoError(t, err)
+
+	withRecords := func(fn func([]index.Record) []index.Record) []index.Record {
+		return fn(append([]index.Record{}, records...))
+	}
+
+	for _, tc := range []struct {
+		name        string
+		car         []byte
+		noIndexChk  bool
+		expectErr   error
+		expectErrIn string
+	}{
+		{
+			name: "carv2 from go-car",
+			car:  buildCarV2(t, pragmaBytes(t, nil), v1.Bytes(), records, true),
+		},
+		{
+			name: "carv2 without index",
+			car:  buildCarV2(t, pragmaBytes(t, nil), v1.Bytes(), nil, false),
+		},
+		{
+			name: "carv2 with wrong offset",
+			car: buildCarV2(t, pragmaBytes(t, nil), v1.Bytes(), withRecords(func(r []index.Record) []index.Record {
+				r[5].Offset++
+				return r
+			}), true),
+			expectErr: traversal.ErrBadIndex,
+		},
+		{
+			name:       "carv2 with wrong offset, not checked",
+			noIndexChk: true,
+			car: buildCarV2(t, pragmaBytes(t, nil), v1.Bytes(), withRecords(func(r []index.Record) []index.Record {
+				r[5].Offset++
+				return r
+			}), true),
+		},
+		{
+			name: "carv2 with unindexed block",
+			car: buildCarV2(t, pragmaBytes(t, nil), v1.Bytes(), withRecords(func(r []index.Record) []index.Record {
+				return append(r[:5], r[6:]...)
+			}), true),
+			expectErr: traversal.ErrBadIndex,
+		},
+		{
+			name: "carv2 with extraneous index entry",
+			car: buildCarV2(t, pragmaBytes(t, nil), v1.Bytes(), withRecords(func(r []index.Record) []index.Record {
+				return append(r, index.Record{Cid: extraneousCid, Offset: r[3].Offset})
+			}), true),
+			expectErr: traversal.ErrBadIndex,
+		},
+		{
+			name:      "carv2 with mismatched pragma roots",
+			car:       buildCarV2(t, pragmaBytes(t, []cid.Cid{extraneousCid}), v1.Bytes(), records, true),
+			expectErr: traversal.ErrBadRoots,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := traversal.Config{
+				Root:             root,
+				Selector:         selectorparse.CommonSelector_ExploreAllRecursively,
+				AllowCARv2:       true,
+				VerifyCARv2Index: !tc.noIndexChk,
+			}
+			result, err := cfg.VerifyCar(ctx, bytes.NewReader(tc.car), newOutputLinkSystem())
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, uint64(len(allBlocks)), result.BlocksIn)
+		})
+	}
+
+	t.Run("carv1 unaffected", func(t *testing.T) {
+		cfg := traversal.Config{
+			Root:             root,
+			Selector:         selectorparse.CommonSelector_ExploreAllRecursively,
+			AllowCARv2:       true,
+			VerifyCARv2Index: true,
+		}
+		_, err := cfg.VerifyCar(ctx, bytes.NewReader(v1.Bytes()), newOutputLinkSystem())
+		require.NoError(t, err)
+	})
+
+	t.Run("carv2 from makeCarStream", func(t *testing.T) {
+		cfg := traversal.Config{
+			Root:             root,
+			Selector:         selectorparse.CommonSelector_ExploreAllRecursively,
+			AllowCARv2:       true,
+			VerifyCARv2Index: true,
+		}
+		carStream, _ := makeCarStream(t, ctx, []cid.Cid{root}, consumedBlocks(allBlocks), true, false, false, nil, false, false)
+		_, err := cfg.VerifyCar(ctx, carStream, newOutputLinkSystem())
+		require.NoError(t, err)
+	})
+}
+
+// pragmaBytes produces a CARv2 pragma, optionally including roots, which a
+// standard pragma does not.
+func pragmaBytes(t *testing.T, roots []cid.Cid) []byte {
+	if len(roots) == 0 {
+		return car.Pragma
+	}
+	n, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "roots", qp.List(int64(len(roots)), func(la datamodel.ListAssembler) {
+			for _, r := range roots {
+				qp.ListEntry(la, qp.Link(cidlink.Link{Cid: r}))
+			}
+		}))
+		qp.MapEntry(ma, "version", qp.Int(2))
+	})
+	require.NoError(t, err)
+	byts, err := ipld.Encode(n, dagcbor.Encode)
+	require.NoError(t, err)
+	return append(varint.ToUvarint(uint64(len(byts))), byts...)
+}
+
+// buildCarV2 assembles a CARv2 from its parts; the data offset is always set as
+// if the pragma were the standard 11 bytes, as go-car assumes.
+func buildCarV2(t *testing.T, pragma []byte, v1 []byte, records []index.Record, withIndex bool) []byte {
+	var buf bytes.Buffer
+	buf.Write(pragma)
+	header := car.NewHeader(uint64(len(v1)))
+	if withIndex {
+		header = header.WithIndexPadding(0)
+	} else {
+		header.IndexOffset = 0
+	}
+	_, err := header.WriteTo(&buf)
+	require.NoError(t, err)
+	buf.Write(v1)
+	if withIndex {
+		idx, err := index.New(multicodec.CarMultihashIndexSorted)
+		require.NoError(t, err)
+		require.NoError(t, idx.Load(records))
+		_, err = index.WriteTo(idx, &buf)
+		require.NoError(t, err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,193 @@
+package traversal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// VerifyRawBlock reads a single raw block body, as returned by a Trustless
+// Gateway for a format=raw (application/vnd.ipld.raw) request, from the
+// provided reader, verifies that it hashes to the multihash of the root CID and
+// writes it to the provided LinkSystem.
+//
+// No more than maxSize bytes will be read from the reader and a body exceeding
+// that size will result in an ErrBlockTooLarge error. A maxSize of zero uses
+// the go-car section limit (8 MiB), as VerifyCar does for blocks in a CAR.
+//
+// If the context is cancelled while the body is being read, the read is
+// interrupted in the same way as VerifyCar.
+//
+// No codec or multihash policy is applied to the root CID, any hash function
+// supported by go-multihash is accepted; use Config.VerifyRawBlock to apply
+// AllowedCodecs, AllowedMultihashes and the identity CID limits.
+//
+// The returned TraversalResult will record a single block in and out on
+// success.
+func VerifyRawBlock(
+	ctx context.Context,
+	root cid.Cid,
+	rdr io.Reader,
+	maxSize uint64,
+	lsys linking.LinkSystem,
+) (TraversalResult, error) {
+	return verifyRawBlock(ctx, root, rdr, maxSize, lsys)
+}
+
+// VerifyRawBlock reads a single raw block body for the Config's Root, as
+// VerifyRawBlock does, applying the Config's AllowedCodecs, AllowedMultihashes,
+// MaxIdentityDigestSize and RejectIdentity to the Root before reading, and its
+// MaxBlockSize and MaxDuration to the read. The Selector and all other options
+// are ignored.
+func (cfg Config) VerifyRawBlock(
+	ctx context.Context,
+	rdr io.Reader,
+	lsys linking.LinkSystem,
+) (TraversalResult, error) {
+	if err := cfg.checkCodec(cfg.Root); err != nil {
+		return TraversalResult{}, err
+	}
+	if err := cfg.checkMultihash(cfg.Root); err != nil {
+		return TraversalResult{}, err
+	}
+	if cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.MaxDuration, ErrDeadline)
+		defer cancel()
+	}
+	return verifyRawBlock(ctx, cfg.Root, rdr, cfg.MaxBlockSize, lsys)
+}
+
+func verifyRawBlock(
+	ctx context.Context,
+	root cid.Cid,
+	rdr io.Reader,
+	maxSize uint64,
+	lsys linking.LinkSystem,
+) (TraversalResult, error) {
+	if err := ctx.Err(); err != nil {
+		return TraversalResult{}, err
+	}
+
+	if maxSize == 0 {
+		maxSize = car.DefaultMaxAllowedSectionSize
+	}
+	data, err := readRawBlock(ctx, io.LimitReader(rdr, int64(maxSize)+1), interruptReader(rdr))
+	if err != nil {
+		if ctx.Err() != nil {
+			return TraversalResult{}, interruptedError(ctx, TraversalResult{})
+		}
+		return TraversalResult{}, err
+	}
+	if uint64(len(data)) > maxSize {
+		return TraversalResult{}, fmt.Errorf("%w: more than %d bytes", ErrBlockTooLarge, maxSize)
+	}
+
+	if err := checkBlockHash(root, data); err != nil {
+		return TraversalResult{}, err
+	}
+
+	w, wc, err := lsys.StorageWriteOpener(linking.LinkContext{Ctx: ctx})
+	if err != nil {
+		return TraversalResult{}, err
+	}
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		return TraversalResult{}, err
+	}
+	if err := wc(cidlink.Link{Cid: root}); err != nil {
+		return TraversalResult{}, err
+	}
+
+	return TraversalResult{
+		BlocksIn:  1,
+		BytesIn:   uint64(len(data)),
+		BlocksOut: 1,
+		BytesOut:  uint64(len(data)),
+	}, nil
+}
+
+// readRawBlock reads the whole of rdr, interrupting the read with interrupt if
+// the context is cancelled. If the underlying reader can't be interrupted then
+// the read is abandoned instead, as openBlockReader does.
+func readRawBlock(ctx context.Context, rdr io.Reader, interrupt func()) ([]byte, error) {
+	if interrupt != nil {
+		stop := context.AfterFunc(ctx, interrupt)
+		defer stop()
+	}
+	if interrupt != nil || ctx.Done() == nil {
+		return io.ReadAll(rdr)
+	}
+	type result struct {
+		data []byte
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		data, err := io.ReadAll(rdr)
+		ch <- result{data, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.data, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WriteRawBlock loads the block for the given root CID from the provided
+// LinkSystem and writes its raw bytes to the provided writer, suitable for use
+// as the body of a Trustless Gateway format=raw (application/vnd.ipld.raw)
+// response.
+//
+// The LinkSystem is responsible for verifying the integrity of the loaded data
+// unless it is configured with TrustedStorage.
+//
+// The returned TraversalResult will record a single block out on success.
+func WriteRawBlock(
+	ctx context.Context,
+	root cid.Cid,
+	lsys linking.LinkSystem,
+	w io.Writer,
+) (TraversalResult, error) {
+	data, err := lsys.LoadRaw(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: root})
+	if err != nil {
+		return TraversalResult{}, traversalError(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return TraversalResult{}, err
+	}
+	return TraversalResult{
+		BlocksOut: 1,
+		BytesOut:  uint64(len(data)),
+	}, nil
+}
+
+// checkBlockHash verifies that data hashes to the multihash of the expected
+// CID, using the same hash function and digest length.
+func checkBlockHash(expected cid.Cid, data []byte) error {
+	dmh, err := multihash.Decode(expected.Hash())
+	if err != nil {
+		return err
+	}
+	if dmh.Code == multihash.IDENTITY {
+		if !bytes.Equal(dmh.Digest, data) {
+			return fmt.Errorf("%w: data does not match identity CID %s", ErrUnexpectedBlock, expected)
+		}
+		return nil
+	}
+	actual, err := multihash.Sum(data, dmh.Code, dmh.Length)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(actual, expected.Hash()) {
+		return fmt.Errorf("%w: data does not hash to %s", ErrUnexpectedBlock, expected)
+	}
+	return nil
+}
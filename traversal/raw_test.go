@@ -0,0 +1,196 @@
+package traversal_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/ipld/go-trustless-utils/traversal"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyRawBlock(t *testing.T) {
+	data := []byte("this is a raw block")
+	sha256Cid := must(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum(data))
+	blake3Cid := must(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.BLAKE3, MhLength: -1}.Sum(data))
+	cborCid := must(cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}.Sum(data))
+	identityCid := cid.NewCidV1(cid.Raw, must(multihash.Sum(data, multihash.IDENTITY, -1)))
+	otherCid := must(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte("nope")))
+
+	for _, tc := range []struct {
+		name      string
+		root      cid.Cid
+		data      []byte
+		maxSize   uint64
+		expectErr error
+	}{
+		{name: "sha2-256", root: sha256Cid, data: data},
+		{name: "blake3", root: blake3Cid, data: data},
+		{name: "non-raw codec", root: cborCid, data: data},
+		{name: "identity", root: identityCid, data: data},
+		{name: "exact max size", root: sha256Cid, data: data, maxSize: uint64(len(data))},
+		{name: "over max size", root: sha256Cid, data: data, maxSize: uint64(len(data)) - 1, expectErr: traversal.ErrBlockTooLarge},
+		{name: "mismatched hash", root: otherCid, data: data, expectErr: traversal.ErrUnexpectedBlock},
+		{name: "truncated", root: sha256Cid, data: data[:len(data)-1], expectErr: traversal.ErrUnexpectedBlock},
+		{name: "identity mismatch", root: identityCid, data: []byte("nope"), expectErr: traversal.ErrUnexpectedBlock},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			lsys := newStoreLinkSystem()
+
+			result, err := traversal.VerifyRawBlock(context.Background(), tc.root, bytes.NewReader(tc.data), tc.maxSize, lsys)
+			if tc.expectErr != nil {
+				req.ErrorIs(err, tc.expectErr)
+				req.Equal(traversal.TraversalResult{}, result)
+				return
+			}
+			req.NoError(err)
+			req.Equal(uint64(1), result.BlocksIn)
+			req.Equal(uint64(len(tc.data)), result.BytesIn)
+			req.Equal(uint64(1), result.BlocksOut)
+			req.Equal(uint64(len(tc.data)), result.BytesOut)
+
+			stored, err := lsys.LoadRaw(linking.LinkContext{}, cidlink.Link{Cid: tc.root})
+			req.NoError(err)
+			req.Equal(tc.data, stored)
+
+			// round-trip through the server side writer
+			var buf bytes.Buffer
+			result, err = traversal.WriteRawBlock(context.Background(), tc.root, lsys, &buf)
+			req.NoError(err)
+			req.Equal(uint64(1), result.BlocksOut)
+			req.Equal(uint64(len(tc.data)), result.BytesOut)
+			req.Equal(tc.data, buf.Bytes())
+		})
+	}
+}
+
+func TestWriteRawBlockMissing(t *testing.T) {
+	lsys := newStoreLinkSystem()
+	root := must(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte("nope")))
+
+	var buf bytes.Buffer
+	_, err := traversal.WriteRawBlock(context.Background(), root, lsys, &buf)
+	require.ErrorIs(t, err, traversal.ErrMissingBlock)
+	require.Equal(t, 0, buf.Len())
+}
+
+func TestVerifyRawBlockReadError(t *testing.T) {
+	lsys := cidlink.DefaultLinkSystem()
+	root := must(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte("nope")))
+	_, err := traversal.VerifyRawBlock(context.Background(), root, errReader{errors.New("something wicked this way comes")}, 0, lsys)
+	require.ErrorContains(t, err, "something wicked this way comes")
+}
+
+func TestVerifyRawBlockDefaultMaxSize(t *testing.T) {
+	// an unbounded body is cut off at the default limit rather than read whole
+	lsys := cidlink.DefaultLinkSystem()
+	root := must(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum([]byte("nope")))
+	_, err := traversal.VerifyRawBlock(context.Background(), root, trustlesstestutil.ZeroReader{}, 0, lsys)
+	require.ErrorIs(t, err, traversal.ErrBlockTooLarge)
+}
+
+func TestVerifyRawBlockCancellation(t *testing.T) {
+	data := []byte("this is a raw block")
+	root := must(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum(data))
+
+	for _, tc := range []struct {
+		name   string
+		reader func(*blockingReader) io.Reader
+	}{
+		{name: "plain reader", reader: func(br *blockingReader) io.Reader { return br }},
+		{name: "closing reader", reader: func(br *blockingReader) io.Reader { return closingReader{br} }},
+		{name: "deadline reader", reader: func(br *blockingReader) io.Reader { return deadlineReader{br} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			br := newBlockingReader(data[:5])
+			t.Cleanup(br.unblock)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				<-br.stalled
+				cancel()
+			}()
+
+			result, err := traversal.VerifyRawBlock(ctx, root, tc.reader(br), 0, newOutputLinkSystem())
+			require.ErrorIs(t, err, context.Canceled)
+			require.Equal(t, traversal.TraversalResult{}, result)
+		})
+	}
+}
+
+func TestConfigVerifyRawBlock(t *testing.T) {
+	data := []byte("this is a raw block")
+	sha256Cid := must(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}.Sum(data))
+	sha512Cid := must(cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_512, MhLength: -1}.Sum(data))
+	cborCid := must(cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}.Sum(data))
+	identityCid := cid.NewCidV1(cid.Raw, must(multihash.Sum(data, multihash.IDENTITY, -1)))
+
+	for _, tc := range []struct {
+		name      string
+		cfg       traversal.Config
+		reader    func() io.Reader
+		expectErr error
+	}{
+		{name: "default policy", cfg: traversal.Config{Root: sha256Cid}},
+		{name: "default policy, disallowed hash", cfg: traversal.Config{Root: sha512Cid}, expectErr: traversal.ErrDisallowedHash},
+		{name: "allowed hash", cfg: traversal.Config{Root: sha512Cid, AllowedMultihashes: []uint64{multihash.SHA2_512}}},
+		{name: "allowed codecs", cfg: traversal.Config{Root: sha256Cid, AllowedCodecs: []uint64{cid.Raw}}},
+		{name: "disallowed codec", cfg: traversal.Config{Root: cborCid, AllowedCodecs: []uint64{cid.Raw}}, expectErr: traversal.ErrDisallowedCodec},
+		{name: "identity", cfg: traversal.Config{Root: identityCid}},
+		{name: "rejected identity", cfg: traversal.Config{Root: identityCid, RejectIdentity: true}, expectErr: traversal.ErrDisallowedHash},
+		{name: "identity too large", cfg: traversal.Config{Root: identityCid, MaxIdentityDigestSize: 4}, expectErr: traversal.ErrIdentityTooLarge},
+		{name: "max block size", cfg: traversal.Config{Root: sha256Cid, MaxBlockSize: 4}, expectErr: traversal.ErrBlockTooLarge},
+		{
+			name: "max duration",
+			cfg:  traversal.Config{Root: sha256Cid, MaxDuration: 50 * time.Millisecond},
+			reader: func() io.Reader {
+				br := newBlockingReader(data[:5])
+				t.Cleanup(br.unblock)
+				return closingReader{br}
+			},
+			expectErr: traversal.ErrDeadline,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			var rdr io.Reader = bytes.NewReader(data)
+			if tc.reader != nil {
+				rdr = tc.reader()
+			}
+			result, err := tc.cfg.VerifyRawBlock(context.Background(), rdr, newOutputLinkSystem())
+			if tc.expectErr != nil {
+				req.ErrorIs(err, tc.expectErr)
+				req.Equal(traversal.TraversalResult{}, result)
+				return
+			}
+			req.NoError(err)
+			req.Equal(uint64(1), result.BlocksOut)
+			req.Equal(uint64(len(data)), result.BytesOut)
+		})
+	}
+}
+
+type errReader struct {
+	err error
+}
+
+func (er errReader) Read([]byte) (int, error) {
+	return 0, er.err
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
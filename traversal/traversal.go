@@ -7,8 +7,9 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"time"
 
-	// include all the codecs we care about
+	// include all the codecs we care about by default, see Config.Decoders
 	dagpb "github.com/ipld/go-codec-dagpb"
 	_ "github.com/ipld/go-ipld-prime/codec/cbor"
 	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
@@ -22,6 +23,7 @@ import (
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-unixfsnode"
 	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime/codec"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
@@ -29,33 +31,122 @@ import (
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	ipldtraversal "github.com/ipld/go-ipld-prime/traversal"
 	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/multiformats/go-multicodec"
 	"go.uber.org/multierr"
 )
 
 var (
-	ErrMalformedCar    = errors.New("malformed CAR")
-	ErrBadVersion      = errors.New("bad CAR version")
-	ErrBadRoots        = errors.New("CAR root CID mismatch")
-	ErrUnexpectedBlock = errors.New("unexpected block in CAR")
-	ErrExtraneousBlock = errors.New("extraneous block in CAR")
-	ErrMissingBlock    = errors.New("missing block in CAR")
+	ErrMalformedCar     = errors.New("malformed CAR")
+	ErrBadVersion       = errors.New("bad CAR version")
+	ErrBadRoots         = errors.New("CAR root CID mismatch")
+	ErrUnexpectedBlock  = errors.New("unexpected block in CAR")
+	ErrExtraneousBlock  = errors.New("extraneous block in CAR")
+	ErrMissingBlock     = errors.New("missing block in CAR")
+	ErrBlockTooLarge    = errors.New("block exceeds maximum size")
+	ErrTooManyBytes     = errors.New("blocks exceed maximum total bytes")
+	ErrDepthExceeded    = errors.New("maximum DAG depth exceeded")
+	ErrPathTooLong      = errors.New("maximum path length exceeded")
+	ErrNodesExceeded    = errors.New("node budget exceeded")
+	ErrDeadline         = errors.New("verification deadline exceeded")
+	ErrIdleTimeout      = errors.New("provider stalled, idle timeout between blocks exceeded")
+	ErrBadIndex         = errors.New("CARv2 index does not match data payload")
+	ErrEmptyBatch       = errors.New("batch contains no traversals")
+	ErrDisallowedCodec  = errors.New("codec not allowed")
+	ErrUnknownCodec     = errors.New("no decoder available for codec")
+	ErrDisallowedHash   = errors.New("multihash function not allowed")
+	ErrIdentityTooLarge = errors.New("identity CID digest exceeds maximum size")
 )
 
+// BlockStream is a source of blocks for VerifyBlockStream. Next should return
+// io.EOF once there are no more blocks.
+//
+// See NewChannelBlockStream, NewLengthPrefixedBlockStream and BlockStreamFunc
+// for adapters from common block sources.
 type BlockStream interface {
 	Next(ctx context.Context) (blocks.Block, error)
 }
 
+// DefaultAllowedMultihashes is the set of multihash functions accepted in CIDs
+// when Config.AllowedMultihashes is not set.
+var DefaultAllowedMultihashes = []uint64{multihash.SHA2_256, multihash.BLAKE3, multihash.IDENTITY}
+
+// DefaultMaxIdentityDigestSize is the maximum length of the digest of an
+// identity CID when Config.MaxIdentityDigestSize is not set. It matches the
+// limit used by boxo.
+const DefaultMaxIdentityDigestSize = 128
+
 var protoChooser = dagpb.AddSupportToChooser(basicnode.Chooser)
 
 type Config struct {
-	Root               cid.Cid        // The single root we expect to appear in the CAR and that we use to run our traversal against
-	AllowCARv2         bool           // If true, allow CARv2 files to be received, otherwise strictly only allow CARv1
-	Selector           datamodel.Node // The selector to execute, starting at the provided Root, to verify the contents of the CAR
-	CheckRootsMismatch bool           // Check if roots match expected behavior
-	ExpectDuplicatesIn bool           // Handles whether the incoming stream has duplicates
-	WriteDuplicatesOut bool           // Handles whether duplicates should be written a second time as blocks
-	MaxBlocks          uint64         // set a budget for the traversal
-	OnBlockIn          func(uint64)   // a callback whenever a block is read the incoming source, recording the number of bytes in the block data
+	Root                  cid.Cid                                      // The single root we expect to appear in the CAR and that we use to run our traversal against
+	AllowCARv2            bool                                         // If true, allow CARv2 files to be received, otherwise strictly only allow CARv1
+	VerifyCARv2Index      bool                                         // If true, and a CARv2 is received, check that its index (if present) exactly matches the data payload and that any roots in its pragma match the data payload roots
+	Selector              datamodel.Node                               // The selector to execute, starting at the provided Root, to verify the contents of the CAR
+	CheckRootsMismatch    bool                                         // Check if roots match expected behavior
+	ExpectDuplicatesIn    bool                                         // Handles whether the incoming stream has duplicates
+	WriteDuplicatesOut    bool                                         // Handles whether duplicates should be written a second time as blocks
+	MaxBlocks             uint64                                       // set a budget for the traversal
+	MaxBlockSize          uint64                                       // the maximum size of the data of any single incoming block, checked before allocation when reading a CAR; defaults to the go-car section limit (8 MiB) for CARs if unset
+	MaxTotalBytes         uint64                                       // the maximum total bytes of block data read from the incoming source, checked before allocation when reading a CAR; no limit if unset
+	MaxDepth              uint64                                       // the maximum number of path segments of any node visited during the traversal; no limit if unset
+	MaxPathLength         uint64                                       // the maximum length, in bytes, of the string form of the path of any node visited during the traversal; no limit if unset
+	MaxNodes              uint64                                       // set a node budget for the traversal; no limit if unset
+	MaxDuration           time.Duration                                // the maximum wall-clock time VerifyBlockStream may take before failing with ErrDeadline; no limit if unset
+	IdleTimeout           time.Duration                                // the maximum time VerifyBlockStream will wait for each block from the BlockStream before failing with ErrIdleTimeout; no limit if unset
+	OnBlockIn             func(uint64)                                 // a callback whenever a block is read the incoming source, recording the number of bytes in the block data
+	OnBlock               func(BlockEvent)                             // a callback for every block read from the incoming source or written to the LinkSystem, and for every identity CID encountered; Traverse reports every block loaded from the LinkSystem
+	Decoders              map[uint64]codec.Decoder                     // if set, the only decoders that may be used to decode blocks, keyed by multicodec code, otherwise the LinkSystem's DecoderChooser (by default the global multicodec registry) is used
+	AllowedMultihashes    []uint64                                     // the multihash functions that may be used in CIDs, other functions, and digests truncated below the function's default length, fail with ErrDisallowedHash before blocks are read from the incoming source; defaults to DefaultAllowedMultihashes if unset or empty when verifying, Traverse applies no restriction unless this, MaxIdentityDigestSize or RejectIdentity is set
+	MaxIdentityDigestSize uint64                                       // the maximum length of the digest of an identity CID, larger identity CIDs fail with ErrIdentityTooLarge; defaults to DefaultMaxIdentityDigestSize if unset
+	RejectIdentity        bool                                         // if true, identity CIDs fail with ErrDisallowedHash, regardless of AllowedMultihashes
+	AllowedCodecs         []uint64                                     // if set, blocks whose CIDs use any other codec fail with ErrDisallowedCodec, before they are read from the incoming source; no restriction if unset
+	PrototypeChooser      ipldtraversal.LinkTargetNodePrototypeChooser // the chooser for the node prototypes used to decode blocks; defaults to basicnode with dag-pb support if unset
+	Clock                 Clock                                        // the source of the current time for the timing statistics in the TraversalResult; defaults to the system clock if unset
+}
+
+// Clock provides the current time, it may be set on a Config to make the timing
+// statistics in a TraversalResult deterministic in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (cfg Config) clock() Clock {
+	if cfg.Clock != nil {
+		return cfg.Clock
+	}
+	return systemClock{}
+}
+
+// BlockDirection describes whether a BlockEvent is for a block read from the
+// incoming source or written out to the LinkSystem.
+type BlockDirection string
+
+const (
+	BlockIn  BlockDirection = "in"
+	BlockOut BlockDirection = "out"
+)
+
+// BlockEvent describes a single block observed during verification, it is
+// passed to the Config's OnBlock callback.
+//
+// Identity CIDs are neither read from the incoming source nor written to the
+// LinkSystem, but are reported once with the BlockIn direction and Identity
+// set, where Size is the length of the inlined digest.
+type BlockEvent struct {
+	Direction BlockDirection
+	Cid       cid.Cid // the CID as linked in the DAG, which may differ in codec or version from the CID in the incoming source
+	Codec     uint64
+	Size      uint64 // the length of the block data
+	// Path is the traversal path the block was loaded at. Blocks loaded without
+	// a path, such as those loaded internally by ADLs like UnixFS, are
+	// attributed to the path of the most recent block that had one.
+	Path      datamodel.Path
+	Duplicate bool
+	Identity  bool
 }
 
 // TraversalResult provides the results of a successful traversal. Byte counting
@@ -69,6 +160,26 @@ type TraversalResult struct {
 	BytesIn   uint64
 	BlocksOut uint64
 	BytesOut  uint64
+
+	// Timing statistics are measured with the Config's Clock from the start of
+	// the call to VerifyCar or VerifyBlockStream, or from the start of each
+	// traversal in a Batch. Durations for events that did not occur are zero.
+	Duration          time.Duration // the total time taken, including the check for the end of the stream
+	TimeToFirstBlock  time.Duration // the time until the first block was received from the incoming source
+	TimeToRoot        time.Duration // the time until the root block was received, verified and written to the LinkSystem
+	MinBlockInterval  time.Duration // the shortest time between consecutive blocks received from the incoming source
+	MaxBlockInterval  time.Duration // the longest time between consecutive blocks received from the incoming source
+	MeanBlockInterval time.Duration // the mean time between consecutive blocks received from the incoming source
+}
+
+// Throughput returns the effective rate, in bytes per second, at which block
+// data was received from the incoming source over the Duration of the
+// verification. It is zero if Duration is zero.
+func (tr TraversalResult) Throughput() float64 {
+	if tr.Duration <= 0 {
+		return 0
+	}
+	return float64(tr.BytesIn) / tr.Duration.Seconds()
 }
 
 // CheckPath will check the lastPath against the expectedPath, returning an
@@ -102,6 +213,14 @@ func CheckPath(expectPath datamodel.Path, lastPath datamodel.Path) error {
 // that duplicates are not expected from the CAR being verified but need to be
 // written back out to the LinkSystem.
 //
+// Cancellation of the context interrupts reads from the provided reader that
+// are blocked waiting for data: if the reader has a SetReadDeadline method
+// (such as a net.Conn) its read deadline is set to the past; otherwise if it is
+// an io.Closer (such as an http.Response Body) it is closed. Other readers are
+// abandoned, leaving a blocked read in the background. In all cases the
+// returned error wraps the context's error along with the progress made up to
+// that point, which is also returned as the TraversalResult.
+//
 // Verification is performed according to the CAR construction rules contained
 // within the Trustless, and Path Gateway specifications:
 //
@@ -113,8 +232,33 @@ func (cfg Config) VerifyCar(
 	rdr io.Reader,
 	lsys linking.LinkSystem,
 ) (TraversalResult, error) {
-	cbr, err := car.NewBlockReader(rdr, car.WithTrustedCAR(false))
+	start := cfg.clock().Now()
+	if cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.MaxDuration, ErrDeadline)
+		defer cancel()
+	}
+
+	interrupt := interruptReader(rdr)
+	if interrupt != nil {
+		stop := context.AfterFunc(ctx, interrupt)
+		defer stop()
+	}
+
+	open := func() (*car.BlockReader, error) {
+		return car.NewBlockReader(rdr, car.WithTrustedCAR(false))
+	}
+	var v2check *carV2Checker
+	if cfg.AllowCARv2 && cfg.VerifyCARv2Index {
+		v2check = &carV2Checker{}
+		open = func() (*car.BlockReader, error) { return v2check.open(rdr) }
+	}
+
+	cbr, err := openBlockReader(ctx, interrupt != nil, open)
 	if err != nil {
+		if ctx.Err() != nil {
+			return TraversalResult{}, interruptedError(ctx, TraversalResult{})
+		}
 		// TODO: post-1.19: fmt.Errorf("%w: %w", ErrMalformedCar, err)
 		return TraversalResult{}, multierr.Combine(ErrMalformedCar, err)
 	}
@@ -132,7 +276,44 @@ func (cfg Config) VerifyCar(
 	if cfg.CheckRootsMismatch && (len(cbr.Roots) != 1 || cbr.Roots[0] != cfg.Root) {
 		return TraversalResult{}, ErrBadRoots
 	}
-	return cfg.VerifyBlockStream(ctx, blockReaderStream{cbr}, lsys)
+	if v2check != nil && !v2check.isV2 {
+		v2check = nil
+	}
+	if v2check != nil {
+		if err := v2check.checkRoots(cbr.Roots); err != nil {
+			return TraversalResult{}, err
+		}
+	}
+	maxBlockSize := cfg.MaxBlockSize
+	if maxBlockSize == 0 {
+		maxBlockSize = car.DefaultMaxAllowedSectionSize
+	}
+	brs := &blockReaderStream{
+		cbr: cbr,
+		blockLimiter: blockLimiter{
+			maxBlockSize:  maxBlockSize,
+			maxTotalBytes: cfg.MaxTotalBytes,
+		},
+	}
+	if v2check != nil {
+		brs.onSection = v2check.recordSection
+	}
+	var bs BlockStream = brs
+	if (interrupt == nil && ctx.Done() != nil) || cfg.IdleTimeout > 0 {
+		// a stalled read can't be interrupted by the context on this reader,
+		// or we need to watch for an idle timeout, so abandon it when needed;
+		// where the context can't be cancelled and there is no idle timeout
+		// there is nothing to wait on, so reads are made directly
+		bs = &timeoutBlockStream{bs: bs, idleTimeout: cfg.IdleTimeout, abandon: interrupt}
+	}
+	result, err := cfg.verifyBlockStream(ctx, bs, lsys, start)
+	if err != nil || v2check == nil {
+		return result, err
+	}
+	if err := v2check.checkIndex(); err != nil {
+		return TraversalResult{}, err
+	}
+	return result, nil
 }
 
 // VerifyBlockStream reads blocks from a BlockStream and verifies the stream of
@@ -142,6 +323,10 @@ func (cfg Config) VerifyCar(
 // case that duplicates are not expected from the BlockStream being verified but
 // need to be written back out to the LinkSystem.
 //
+// If the context is cancelled the returned error wraps the context's error
+// along with the progress made up to that point, which is also returned as the
+// TraversalResult.
+//
 // Verification is performed according to the CAR construction rules contained
 // within the Trustless, and Path Gateway specifications:
 //
@@ -153,32 +338,124 @@ func (cfg Config) VerifyBlockStream(
 	bs BlockStream,
 	lsys linking.LinkSystem,
 ) (TraversalResult, error) {
-	bt := &writeTracker{onBlockIn: cfg.OnBlockIn}
+	start := cfg.clock().Now()
+	if cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.MaxDuration, ErrDeadline)
+		defer cancel()
+	}
+	if cfg.MaxDuration > 0 || cfg.IdleTimeout > 0 {
+		bs = &timeoutBlockStream{bs: bs, idleTimeout: cfg.IdleTimeout}
+	}
+	return cfg.verifyBlockStream(ctx, bs, lsys, start)
+}
+
+func (cfg Config) verifyBlockStream(
+	ctx context.Context,
+	bs BlockStream,
+	lsys linking.LinkSystem,
+	start time.Time,
+) (TraversalResult, error) {
+	result, err := cfg.traverseBlockStream(ctx, bs, lsys, start)
+	if err != nil {
+		return result, err
+	}
+	result, err = checkStreamEnd(ctx, bs, result)
+	if err != nil {
+		return result, err
+	}
+	result.Duration = cfg.clock().Now().Sub(start)
+	return result, nil
+}
+
+// traverseBlockStream performs the traversal, reading blocks from the
+// BlockStream as they are needed, but doesn't check for the end of the stream.
+// Timing statistics are measured from start.
+func (cfg Config) traverseBlockStream(
+	ctx context.Context,
+	bs BlockStream,
+	lsys linking.LinkSystem,
+	start time.Time,
+) (TraversalResult, error) {
+	bt := &writeTracker{onBlockIn: cfg.OnBlockIn, onBlock: cfg.OnBlock, clock: cfg.clock(), start: start}
 	lsys.TrustedStorage = true // we can rely on the CAR decoder to check CID integrity
 	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
 	lsys.StorageReadOpener = cfg.nextBlockReadOpener(ctx, bs, bt, lsys)
 
 	// perform the traversal
-	lastPath, err := cfg.Traverse(ctx, lsys, nil)
+	// codecs and hashes are checked by the read opener, before each block is
+	// read, so the decoder chooser needn't check them again
+	lastPath, err := cfg.traverse(ctx, lsys, nil, false)
 	if err != nil {
+		if ctx.Err() != nil {
+			return bt.result(lastPath), interruptedError(ctx, bt.result(lastPath))
+		}
 		return TraversalResult{}, traversalError(err)
 	}
-	// make sure we don't have any extraneous data beyond what the traversal needs
-	_, err = bs.Next(ctx)
+	return bt.result(lastPath), nil
+}
+
+// checkStreamEnd makes sure we don't have any extraneous data beyond what the
+// traversal needs.
+func checkStreamEnd(ctx context.Context, bs BlockStream, result TraversalResult) (TraversalResult, error) {
+	_, err := bs.Next(ctx)
 	if err == nil {
 		return TraversalResult{}, ErrExtraneousBlock
 	} else if !errors.Is(err, io.EOF) {
+		if ctx.Err() != nil {
+			return result, interruptedError(ctx, result)
+		}
 		return TraversalResult{}, err
 	}
+	return result, nil
+}
+
+// interruptReader returns a function that will interrupt any blocked reads on
+// the provided reader, or nil if the reader can't be interrupted.
+func interruptReader(rdr io.Reader) func() {
+	switch r := rdr.(type) {
+	case interface{ SetReadDeadline(time.Time) error }:
+		return func() { _ = r.SetReadDeadline(time.Unix(1, 0)) }
+	case io.Closer:
+		return func() { _ = r.Close() }
+	}
+	return nil
+}
+
+// openBlockReader calls open to read the CAR header, if the underlying reader
+// can't be interrupted then the read is abandoned if the context is cancelled.
+// The read is made directly where the context can't be cancelled.
+func openBlockReader(ctx context.Context, interruptible bool, open func() (*car.BlockReader, error)) (*car.BlockReader, error) {
+	if interruptible || ctx.Done() == nil {
+		return open()
+	}
+	type result struct {
+		cbr *car.BlockReader
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		cbr, err := open()
+		ch <- result{cbr, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.cbr, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-	// wait for parser to finish and provide errors or stats
-	return TraversalResult{
-		LastPath:  lastPath,
-		BlocksIn:  bt.blocksIn,
-		BytesIn:   bt.bytesIn,
-		BlocksOut: bt.blocksOut,
-		BytesOut:  bt.bytesOut,
-	}, nil
+// interruptedError describes a verification that was interrupted by the
+// context, wrapping both the context's error and its cause, if different, along
+// with the progress made.
+func interruptedError(ctx context.Context, progress TraversalResult) error {
+	err := fmt.Errorf("verification interrupted after %d blocks (%d bytes) in: %w", progress.BlocksIn, progress.BytesIn, ctx.Err())
+	if cause := context.Cause(ctx); cause != ctx.Err() {
+		// TODO: post-1.19: fmt.Errorf("%w: %w", cause, err)
+		return multierr.Combine(cause, err)
+	}
+	return err
 }
 
 // Traverse performs a traversal using the Config's Selector, starting at the
@@ -189,37 +466,106 @@ func (cfg Config) VerifyBlockStream(
 // go-ipld-prime traversal (such as those encountered by ADLs that are not
 // propagated).
 //
+// AllowedCodecs is applied to every link loaded. AllowedMultihashes,
+// MaxIdentityDigestSize and RejectIdentity are only applied if at least one of
+// them is set, so that data already in the LinkSystem using any hash function
+// can be served without opting in; the defaults apply only when verifying
+// incoming data.
+//
+// If OnBlock is set, each block loaded from the LinkSystem is reported with the
+// BlockOut direction, as it is loaded.
+//
 // Returns the last path visited during the traversal, or an error if the
 // traversal failed.
 func (cfg Config) Traverse(
 	ctx context.Context,
 	lsys linking.LinkSystem,
 	preloader preload.Loader,
+) (datamodel.Path, error) {
+	if cfg.OnBlock != nil && lsys.StorageReadOpener != nil {
+		lsys.StorageReadOpener = cfg.onBlockReadOpener(lsys.StorageReadOpener)
+	}
+	return cfg.traverse(ctx, lsys, preloader, true)
+}
+
+// onBlockReadOpener wraps the LinkSystem's BlockReadOpener for Traverse so that
+// each block loaded is reported to OnBlock, with the BlockOut direction.
+func (cfg Config) onBlockReadOpener(base linking.BlockReadOpener) linking.BlockReadOpener {
+	seen := make(map[cid.Cid]struct{})
+	var path datamodel.Path
+	return func(lc linking.LinkContext, l datamodel.Link) (io.Reader, error) {
+		rdr, err := base(lc, l)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rdr)
+		if closer, ok := rdr.(io.Closer); ok {
+			_ = closer.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cl, ok := l.(cidlink.Link); ok {
+			if lc.LinkPath.Len() > 0 {
+				path = lc.LinkPath
+			}
+			_, duplicate := seen[cl.Cid]
+			seen[cl.Cid] = struct{}{}
+			_, identity, _ := asIdentity(cl.Cid)
+			cfg.OnBlock(BlockEvent{
+				Direction: BlockOut,
+				Cid:       cl.Cid,
+				Codec:     cl.Cid.Prefix().Codec,
+				Size:      uint64(len(data)),
+				Path:      path,
+				Duplicate: duplicate,
+				Identity:  identity,
+			})
+		}
+		return bytes.NewReader(data), nil
+	}
+}
+
+// traverse implements Traverse, checkLinks determines whether the codec and
+// multihash of each link are checked against the Config when decoding.
+func (cfg Config) traverse(
+	ctx context.Context,
+	lsys linking.LinkSystem,
+	preloader preload.Loader,
+	checkLinks bool,
 ) (datamodel.Path, error) {
 	sel, err := selector.CompileSelector(cfg.Selector)
 	if err != nil {
 		return datamodel.Path{}, err
 	}
 
+	lsys.DecoderChooser = cfg.decoderChooser(lsys.DecoderChooser, checkLinks)
 	lsys, ecr := NewErrorCapturingReader(lsys)
+	chooser := cfg.prototypeChooser()
 
 	// run traversal in this goroutine
 	progress := ipldtraversal.Progress{
 		Cfg: &ipldtraversal.Config{
 			Ctx:                            ctx,
 			LinkSystem:                     lsys,
-			LinkTargetNodePrototypeChooser: protoChooser,
+			LinkTargetNodePrototypeChooser: chooser,
 			Preloader:                      preloader,
 		},
 	}
-	if cfg.MaxBlocks > 0 {
+	if cfg.MaxBlocks > 0 || cfg.MaxNodes > 0 {
 		progress.Budget = &ipldtraversal.Budget{
-			LinkBudget: int64(cfg.MaxBlocks) - 1, // first block is already loaded
+			LinkBudget: math.MaxInt64,
 			NodeBudget: math.MaxInt64,
 		}
+		if cfg.MaxBlocks > 0 {
+			progress.Budget.LinkBudget = int64(cfg.MaxBlocks) - 1 // first block is already loaded
+		}
+		if cfg.MaxNodes > 0 {
+			progress.Budget.NodeBudget = int64(cfg.MaxNodes)
+		}
 	}
 
-	rootNode, err := loadNode(ctx, cfg.Root, lsys)
+	rootNode, err := loadNode(ctx, cfg.Root, lsys, chooser)
 	if err != nil {
 		return datamodel.Path{}, fmt.Errorf("failed to load root node: %w", err)
 	}
@@ -228,6 +574,14 @@ func (cfg Config) Traverse(
 	var lastPath datamodel.Path
 	visitor := func(p ipldtraversal.Progress, n datamodel.Node, vr ipldtraversal.VisitReason) error {
 		lastPath = p.Path
+		if cfg.MaxDepth > 0 && uint64(p.Path.Len()) > cfg.MaxDepth {
+			return fmt.Errorf("%w: %d > %d at path [%s]", ErrDepthExceeded, p.Path.Len(), cfg.MaxDepth, p.Path.String())
+		}
+		if cfg.MaxPathLength > 0 {
+			if pl := uint64(len(p.Path.String())); pl > cfg.MaxPathLength {
+				return fmt.Errorf("%w: %d > %d", ErrPathTooLong, pl, cfg.MaxPathLength)
+			}
+		}
 		if vr == ipldtraversal.VisitReason_SelectionMatch {
 			return unixfsnode.BytesConsumingMatcher(p, n)
 		}
@@ -235,6 +589,11 @@ func (cfg Config) Traverse(
 	}
 
 	if err := progress.WalkAdv(rootNode, sel, visitor); err != nil {
+		var be *ipldtraversal.ErrBudgetExceeded
+		if errors.As(err, &be) && be.BudgetKind == "node" {
+			// TODO: post-1.19: fmt.Errorf("%w: %w", ErrNodesExceeded, err)
+			return datamodel.Path{}, multierr.Combine(ErrNodesExceeded, err)
+		}
 		return datamodel.Path{}, err
 	}
 
@@ -245,10 +604,15 @@ func (cfg Config) Traverse(
 	return lastPath, nil
 }
 
-func loadNode(ctx context.Context, rootCid cid.Cid, lsys linking.LinkSystem) (datamodel.Node, error) {
+func loadNode(
+	ctx context.Context,
+	rootCid cid.Cid,
+	lsys linking.LinkSystem,
+	chooser ipldtraversal.LinkTargetNodePrototypeChooser,
+) (datamodel.Node, error) {
 	lnk := cidlink.Link{Cid: rootCid}
 	lnkCtx := linking.LinkContext{Ctx: ctx}
-	proto, err := protoChooser(lnk, lnkCtx)
+	proto, err := chooser(lnk, lnkCtx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to choose prototype for CID %s: %w", rootCid.String(), err)
 	}
@@ -259,6 +623,116 @@ func loadNode(ctx context.Context, rootCid cid.Cid, lsys linking.LinkSystem) (da
 	return rootNode, nil
 }
 
+func (cfg Config) prototypeChooser() ipldtraversal.LinkTargetNodePrototypeChooser {
+	if cfg.PrototypeChooser != nil {
+		return cfg.PrototypeChooser
+	}
+	return protoChooser
+}
+
+// checkCodec returns an ErrDisallowedCodec error if AllowedCodecs is set and
+// does not include the codec of the CID.
+func (cfg Config) checkCodec(c cid.Cid) error {
+	if len(cfg.AllowedCodecs) == 0 {
+		return nil
+	}
+	code := c.Prefix().Codec
+	for _, allowed := range cfg.AllowedCodecs {
+		if code == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s (0x%x) for %s", ErrDisallowedCodec, multicodec.Code(code), code, c)
+}
+
+// checkMultihash returns an ErrDisallowedHash error if the multihash function
+// of the CID is not in AllowedMultihashes (or DefaultAllowedMultihashes), or if
+// its digest is truncated below the default length for that function. Identity
+// CIDs are subject to RejectIdentity and MaxIdentityDigestSize.
+func (cfg Config) checkMultihash(c cid.Cid) error {
+	allowedHashes := cfg.AllowedMultihashes
+	if len(allowedHashes) == 0 {
+		allowedHashes = DefaultAllowedMultihashes
+	}
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return err
+	}
+	if dmh.Code == multihash.IDENTITY {
+		if cfg.RejectIdentity {
+			return fmt.Errorf("%w: identity CIDs are not allowed: %s", ErrDisallowedHash, c)
+		}
+		maxSize := cfg.MaxIdentityDigestSize
+		if maxSize == 0 {
+			maxSize = DefaultMaxIdentityDigestSize
+		}
+		if uint64(dmh.Length) > maxSize {
+			return fmt.Errorf("%w: %d > %d", ErrIdentityTooLarge, dmh.Length, maxSize)
+		}
+	}
+	allowed := false
+	for _, code := range allowedHashes {
+		if dmh.Code == code {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s (0x%x) for %s", ErrDisallowedHash, multicodec.Code(dmh.Code), dmh.Code, c)
+	}
+	if dl, ok := multihash.DefaultLengths[dmh.Code]; ok && dmh.Code != multihash.IDENTITY && dmh.Length < dl {
+		return fmt.Errorf("%w: %s (0x%x) digest truncated to %d bytes for %s", ErrDisallowedHash, multicodec.Code(dmh.Code), dmh.Code, dmh.Length, c)
+	}
+	return nil
+}
+
+// hashPolicySet returns true if any of the multihash options are explicitly
+// set on the Config.
+func (cfg Config) hashPolicySet() bool {
+	return len(cfg.AllowedMultihashes) > 0 || cfg.MaxIdentityDigestSize > 0 || cfg.RejectIdentity
+}
+
+// decoderChooser wraps the LinkSystem's DecoderChooser to apply Decoders, and
+// AllowedCodecs and, if explicitly set, the multihash options if checkLinks is
+// set, and to report unknown codecs with ErrUnknownCodec.
+func (cfg Config) decoderChooser(
+	base func(datamodel.Link) (codec.Decoder, error),
+	checkLinks bool,
+) func(datamodel.Link) (codec.Decoder, error) {
+	return func(lnk datamodel.Link) (codec.Decoder, error) {
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("%w: unsupported link type %T", ErrUnknownCodec, lnk)
+		}
+		if checkLinks {
+			if err := cfg.checkCodec(cl.Cid); err != nil {
+				return nil, err
+			}
+			if cfg.hashPolicySet() {
+				if err := cfg.checkMultihash(cl.Cid); err != nil {
+					return nil, err
+				}
+			}
+		}
+		code := cl.Cid.Prefix().Codec
+		if cfg.Decoders != nil {
+			if decoder, ok := cfg.Decoders[code]; ok {
+				return decoder, nil
+			}
+			return nil, fmt.Errorf("%w: %s (0x%x)", ErrUnknownCodec, multicodec.Code(code), code)
+		}
+		if base == nil {
+			return nil, fmt.Errorf("%w: %s (0x%x)", ErrUnknownCodec, multicodec.Code(code), code)
+		}
+		decoder, err := base(lnk)
+		if err != nil {
+			// TODO: post-1.19: fmt.Errorf("%w: %w", ErrUnknownCodec, err)
+			return nil, multierr.Combine(ErrUnknownCodec, err)
+		}
+		return decoder, nil
+	}
+}
+
 func asIdentity(c cid.Cid) (digest []byte, ok bool, err error) {
 	dmh, err := multihash.Decode(c.Hash())
 	if err != nil {
@@ -286,10 +760,26 @@ func (cfg *Config) nextBlockReadOpener(
 	lsys linking.LinkSystem,
 ) linking.BlockReadOpener {
 	seen := make(map[cid.Cid]struct{})
+	var path datamodel.Path
 	return func(lc linking.LinkContext, l datamodel.Link) (io.Reader, error) {
 		cid := l.(cidlink.Link).Cid
+		if lc.LinkPath.Len() > 0 {
+			path = lc.LinkPath
+		}
+		// reject disallowed codecs and hashes before consuming the block from
+		// the stream
+		if err := cfg.checkCodec(cid); err != nil {
+			return nil, err
+		}
+		if err := cfg.checkMultihash(cid); err != nil {
+			return nil, err
+		}
 
 		if digest, ok, err := asIdentity(cid); ok {
+			bt.recordIdentity(cid, path, digest)
+			if cid == cfg.Root {
+				bt.recordRoot()
+			}
 			return io.NopCloser(bytes.NewReader(digest)), nil
 		} else if err != nil {
 			return nil, err
@@ -297,14 +787,15 @@ func (cfg *Config) nextBlockReadOpener(
 
 		var data []byte
 		var err error
-		if _, ok := seen[cid]; ok {
+		_, duplicate := seen[cid]
+		if duplicate {
 			if cfg.ExpectDuplicatesIn {
 				// duplicate block, but in this case we are expecting the stream to have it
-				data, err = readNextBlock(ctx, bs, cid)
+				data, err = cfg.readNextBlock(ctx, bs, bt, cid)
 				if err != nil {
 					return nil, err
 				}
-				bt.recordBlockIn(data)
+				bt.recordBlockIn(cid, path, true, data)
 				if !cfg.WriteDuplicatesOut {
 					return bytes.NewReader(data), nil
 				}
@@ -321,13 +812,13 @@ func (cfg *Config) nextBlockReadOpener(
 			}
 		} else {
 			seen[cid] = struct{}{}
-			data, err = readNextBlock(ctx, bs, cid)
+			data, err = cfg.readNextBlock(ctx, bs, bt, cid)
 			if err != nil {
 				return nil, err
 			}
-			bt.recordBlockIn(data)
+			bt.recordBlockIn(cid, path, false, data)
 		}
-		bt.recordBlockOut(data)
+		bt.recordBlockOut(cid, path, duplicate, data)
 		w, wc, err := lsys.StorageWriteOpener(lc)
 		if err != nil {
 			return nil, err
@@ -342,18 +833,34 @@ func (cfg *Config) nextBlockReadOpener(
 		if _, err := rdr.Seek(0, io.SeekStart); err != nil {
 			return nil, err
 		}
+		if cid == cfg.Root {
+			bt.recordRoot()
+		}
 		return io.NopCloser(rdr), nil
 	}
 }
 
-func readNextBlock(ctx context.Context, bs BlockStream, expected cid.Cid) ([]byte, error) {
+func (cfg *Config) readNextBlock(ctx context.Context, bs BlockStream, bt *writeTracker, expected cid.Cid) ([]byte, error) {
 	blk, err := bs.Next(ctx)
 	if err != nil {
-		if errors.Is(err, io.EOF) {
+		switch {
+		case errors.Is(err, io.EOF):
 			return nil, format.ErrNotFound{Cid: expected}
+		case errors.Is(err, ErrBlockTooLarge),
+			errors.Is(err, ErrTooManyBytes),
+			errors.Is(err, ErrIdleTimeout),
+			errors.Is(err, ErrDeadline):
+			return nil, err
 		}
 		return nil, multierr.Combine(ErrMalformedCar, err)
 	}
+	bt.recordArrival()
+
+	// the BlockStream may not have been able to check sizes before allocation,
+	// so we check again here
+	if err := checkBlockLimits(uint64(len(blk.RawData())), bt.bytesIn, cfg.MaxBlockSize, cfg.MaxTotalBytes); err != nil {
+		return nil, err
+	}
 
 	// compare by multihash only
 	if !bytes.Equal(blk.Cid().Hash(), expected.Hash()) {
@@ -365,25 +872,106 @@ func readNextBlock(ctx context.Context, bs BlockStream, expected cid.Cid) ([]byt
 
 type writeTracker struct {
 	onBlockIn func(uint64)
+	onBlock   func(BlockEvent)
 
 	blocksIn  uint64
 	blocksOut uint64
 	bytesIn   uint64
 	bytesOut  uint64
+
+	clock         Clock
+	start         time.Time
+	arrivals      int64
+	lastArrival   time.Time
+	firstBlock    time.Duration
+	rootSeen      bool
+	root          time.Duration
+	intervalTotal time.Duration
+	minInterval   time.Duration
+	maxInterval   time.Duration
+}
+
+func (bt *writeTracker) result(lastPath datamodel.Path) TraversalResult {
+	result := TraversalResult{
+		LastPath:         lastPath,
+		BlocksIn:         bt.blocksIn,
+		BytesIn:          bt.bytesIn,
+		BlocksOut:        bt.blocksOut,
+		BytesOut:         bt.bytesOut,
+		Duration:         bt.clock.Now().Sub(bt.start),
+		TimeToFirstBlock: bt.firstBlock,
+		TimeToRoot:       bt.root,
+		MinBlockInterval: bt.minInterval,
+		MaxBlockInterval: bt.maxInterval,
+	}
+	if bt.arrivals > 1 {
+		result.MeanBlockInterval = bt.intervalTotal / time.Duration(bt.arrivals-1)
+	}
+	return result
+}
+
+// recordArrival records the time a block was received from the BlockStream,
+// before it is verified.
+func (bt *writeTracker) recordArrival() {
+	now := bt.clock.Now()
+	if bt.arrivals == 0 {
+		bt.firstBlock = now.Sub(bt.start)
+	} else {
+		interval := now.Sub(bt.lastArrival)
+		if bt.arrivals == 1 || interval < bt.minInterval {
+			bt.minInterval = interval
+		}
+		if interval > bt.maxInterval {
+			bt.maxInterval = interval
+		}
+		bt.intervalTotal += interval
+	}
+	bt.arrivals++
+	bt.lastArrival = now
+}
+
+// recordRoot records the time the root block was verified and written, only
+// the first call has an effect.
+func (bt *writeTracker) recordRoot() {
+	if !bt.rootSeen {
+		bt.rootSeen = true
+		bt.root = bt.clock.Now().Sub(bt.start)
+	}
 }
 
-func (bt *writeTracker) recordBlockIn(data []byte) {
+func (bt *writeTracker) recordBlockIn(c cid.Cid, path datamodel.Path, duplicate bool, data []byte) {
 	bt.blocksIn++
 	bc := uint64(len(data))
 	bt.bytesIn += bc
 	if bt.onBlockIn != nil {
 		bt.onBlockIn(bc)
 	}
+	bt.notify(BlockIn, c, path, duplicate, false, bc)
 }
 
-func (bt *writeTracker) recordBlockOut(data []byte) {
+func (bt *writeTracker) recordBlockOut(c cid.Cid, path datamodel.Path, duplicate bool, data []byte) {
 	bt.blocksOut++
 	bt.bytesOut += uint64(len(data))
+	bt.notify(BlockOut, c, path, duplicate, false, uint64(len(data)))
+}
+
+func (bt *writeTracker) recordIdentity(c cid.Cid, path datamodel.Path, digest []byte) {
+	bt.notify(BlockIn, c, path, false, true, uint64(len(digest)))
+}
+
+func (bt *writeTracker) notify(dir BlockDirection, c cid.Cid, path datamodel.Path, duplicate, identity bool, size uint64) {
+	if bt.onBlock == nil {
+		return
+	}
+	bt.onBlock(BlockEvent{
+		Direction: dir,
+		Cid:       c,
+		Codec:     c.Prefix().Codec,
+		Size:      size,
+		Path:      path,
+		Duplicate: duplicate,
+		Identity:  identity,
+	})
 }
 
 func traversalError(original error) error {
@@ -398,11 +986,3 @@ func traversalError(original error) error {
 		}
 	}
 }
-
-type blockReaderStream struct {
-	cbr *car.BlockReader
-}
-
-func (brs blockReaderStream) Next(ctx context.Context) (blocks.Block, error) {
-	return brs.cbr.Next()
-}
@@ -23,8 +23,11 @@ import (
 	"github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/storage"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/ipld/go-ipld-prime/codec/dagjson"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
@@ -36,6 +39,7 @@ import (
 	trustlesspathing "github.com/ipld/ipld/specs/pkg-go/trustless-pathing"
 	mh "github.com/multiformats/go-multihash"
 	multihash "github.com/multiformats/go-multihash/core"
+	"github.com/multiformats/go-varint"
 	"github.com/stretchr/testify/require"
 )
 
@@ -380,6 +384,95 @@ func TestVerifyCar(t *testing.T) {
 				MaxBlocks: 3,
 			},
 		},
+		{
+			name:      "carv1 over depth errors",
+			blocks:    consumedBlocks(allBlocks),
+			roots:     []cid.Cid{root1},
+			expectErr: "maximum DAG depth exceeded: 5 > 4 at path [Parents/0/Parents/0/Parents]",
+			cfg: traversal.Config{
+				Root:     root1,
+				Selector: allSelector,
+				MaxDepth: 4,
+			},
+		},
+		{
+			name:      "carv1 over path length errors",
+			blocks:    consumedBlocks(allBlocks),
+			roots:     []cid.Cid{root1},
+			expectErr: "maximum path length exceeded",
+			cfg: traversal.Config{
+				Root:          root1,
+				Selector:      allSelector,
+				MaxPathLength: 20,
+			},
+		},
+		{
+			name:      "carv1 over node budget errors",
+			blocks:    consumedBlocks(allBlocks),
+			roots:     []cid.Cid{root1},
+			expectErr: "node budget exceeded",
+			cfg: traversal.Config{
+				Root:     root1,
+				Selector: allSelector,
+				MaxNodes: 10,
+			},
+		},
+		{
+			name:   "carv1 within depth, path length and node budgets",
+			blocks: consumedBlocks(allBlocks),
+			roots:  []cid.Cid{root1},
+			cfg: traversal.Config{
+				Root:          root1,
+				Selector:      allSelector,
+				MaxDepth:      1000,
+				MaxPathLength: 10000,
+				MaxNodes:      10000,
+				MaxDuration:   time.Second,
+				IdleTimeout:   time.Second,
+			},
+		},
+		{
+			name:      "carv1 over total bytes errors",
+			blocks:    consumedBlocks(allBlocks),
+			roots:     []cid.Cid{root1},
+			expectErr: "blocks exceed maximum total bytes",
+			cfg: traversal.Config{
+				Root:          root1,
+				Selector:      allSelector,
+				MaxTotalBytes: sizeOf(consumedBlocks(allBlocks)) - 1,
+			},
+		},
+		{
+			name:   "carv1 at total bytes",
+			blocks: consumedBlocks(allBlocks),
+			roots:  []cid.Cid{root1},
+			cfg: traversal.Config{
+				Root:          root1,
+				Selector:      allSelector,
+				MaxTotalBytes: sizeOf(consumedBlocks(allBlocks)),
+			},
+		},
+		{
+			name:      "unixfs: large sharded file over block size errors",
+			blocks:    consumedBlocks(unixfsFileBlocks),
+			roots:     []cid.Cid{unixfsFile.Root},
+			expectErr: "block exceeds maximum size",
+			cfg: traversal.Config{
+				Root:         unixfsFile.Root,
+				Selector:     allSelector,
+				MaxBlockSize: 1 << 10,
+			},
+		},
+		{
+			name:   "unixfs: large sharded file at block size",
+			blocks: consumedBlocks(unixfsFileBlocks),
+			roots:  []cid.Cid{unixfsFile.Root},
+			cfg: traversal.Config{
+				Root:         unixfsFile.Root,
+				Selector:     allSelector,
+				MaxBlockSize: 256 << 10,
+			},
+		},
 		{
 			name:   "unixfs: large sharded file",
 			blocks: consumedBlocks(unixfsFileBlocks),
@@ -815,6 +908,22 @@ func TestVerifyCar(t *testing.T) {
 					byteCount += bytes
 				}
 			}
+			var eventBlocksIn, eventBytesIn, eventBlocksOut, eventBytesOut uint64
+			if cfg.OnBlock == nil {
+				cfg.OnBlock = func(ev traversal.BlockEvent) {
+					if ev.Identity {
+						return
+					}
+					switch ev.Direction {
+					case traversal.BlockIn:
+						eventBlocksIn++
+						eventBytesIn += ev.Size
+					case traversal.BlockOut:
+						eventBlocksOut++
+						eventBytesOut += ev.Size
+					}
+				}
+			}
 			result, err := cfg.VerifyCar(ctx, carStream, lsys)
 
 			// read the rest of data
@@ -845,6 +954,10 @@ func TestVerifyCar(t *testing.T) {
 					req.Equal(sizeOf(testCase.blocks), result.BytesIn)
 				}
 				req.Equal(result.BytesIn, byteCount)
+				req.Equal(result.BlocksIn, eventBlocksIn)
+				req.Equal(result.BytesIn, eventBytesIn)
+				req.Equal(result.BlocksOut, eventBlocksOut)
+				req.Equal(result.BytesOut, eventBytesOut)
 				if testCase.expectBytesOut > 0 {
 					req.Equal(testCase.expectBytesOut, result.BytesOut)
 				} else {
@@ -871,6 +984,375 @@ func TestVerifyCar(t *testing.T) {
 	}
 }
 
+func TestVerifyCarOnBlock(t *testing.T) {
+	ctx := context.Background()
+	req := require.New(t)
+
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{
+		Bag: make(map[string][]byte),
+	}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+
+	allSelector := selectorparse.CommonSelector_ExploreAllRecursively
+	identityDag := trustlesstestutil.MakeDagWithIdentity(t, lsys)
+	identityBlocks := testutil.ToBlocks(t, lsys, identityDag.Root, allSelector)
+	expectedPaths := make(map[cid.Cid]string)
+	var collect func(unixfs.DirEntry)
+	collect = func(de unixfs.DirEntry) {
+		expectedPaths[de.Root] = datamodel.ParsePath(de.Path).String()
+		for _, child := range de.Children {
+			collect(child)
+		}
+	}
+	collect(identityDag)
+
+	var events []traversal.BlockEvent
+	cfg := traversal.Config{
+		Root:     identityDag.Root,
+		Selector: allSelector,
+		OnBlock:  func(ev traversal.BlockEvent) { events = append(events, ev) },
+	}
+	carStream, errorCh := makeCarStream(t, ctx, []cid.Cid{identityDag.Root}, consumedBlocks(identityBlocks), false, false, false, nil, false, false)
+	outLsys := cidlink.DefaultLinkSystem()
+	outLsys.SetWriteStorage(&memstore.Store{Bag: make(map[string][]byte)})
+	_, err := cfg.VerifyCar(ctx, carStream, outLsys)
+	req.NoError(err)
+	select {
+	case err := <-errorCh:
+		req.NoError(err)
+	default:
+	}
+
+	var identities int
+	for _, ev := range events {
+		req.Equal(expectedPaths[ev.Cid], ev.Path.String(), "path of %s", ev.Cid)
+		req.Equal(ev.Cid.Prefix().Codec, ev.Codec)
+		req.False(ev.Duplicate)
+		if ev.Identity {
+			identities++
+			req.Equal(traversal.BlockIn, ev.Direction)
+			req.Equal(uint64(len(ev.Cid.Hash())-2), ev.Size)
+		}
+	}
+	req.Equal(1, identities)
+	req.Len(events, len(identityBlocks)*2+identities)
+}
+
+func TestTraverseOnBlock(t *testing.T) {
+	ctx := context.Background()
+	req := require.New(t)
+
+	lsys := newStoreLinkSystem()
+	allSelector := selectorparse.CommonSelector_ExploreAllRecursively
+	identityDag := trustlesstestutil.MakeDagWithIdentity(t, lsys)
+	identityBlocks := testutil.ToBlocks(t, lsys, identityDag.Root, allSelector)
+
+	var events []traversal.BlockEvent
+	cfg := traversal.Config{
+		Root:     identityDag.Root,
+		Selector: allSelector,
+		OnBlock:  func(ev traversal.BlockEvent) { events = append(events, ev) },
+	}
+	_, err := cfg.Traverse(ctx, lsys, nil)
+	req.NoError(err)
+
+	req.NotEmpty(events)
+	req.Equal(identityDag.Root, events[0].Cid)
+	var identities int
+	for _, ev := range events {
+		req.Equal(traversal.BlockOut, ev.Direction)
+		req.Equal(ev.Cid.Prefix().Codec, ev.Codec)
+		if ev.Identity {
+			identities++
+			continue
+		}
+		data, err := lsys.LoadRaw(linking.LinkContext{}, cidlink.Link{Cid: ev.Cid})
+		req.NoError(err)
+		req.Equal(uint64(len(data)), ev.Size)
+	}
+	req.Equal(1, identities)
+	req.Len(events, len(identityBlocks)+identities)
+}
+
+func TestVerifyCarOnBlockDuplicates(t *testing.T) {
+	ctx := context.Background()
+	req := require.New(t)
+
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{
+		Bag: make(map[string][]byte),
+	}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+
+	allSelector := selectorparse.CommonSelector_ExploreAllRecursively
+	fileWithDups := unixfs.GenerateFile(t, &lsys, trustlesstestutil.ZeroReader{}, 4<<20)
+	fileWithDupsBlocks := testutil.ToBlocks(t, lsys, fileWithDups.Root, allSelector)
+
+	var dupsIn, dupsOut int
+	cfg := traversal.Config{
+		Root:               fileWithDups.Root,
+		Selector:           allSelector,
+		ExpectDuplicatesIn: true,
+		WriteDuplicatesOut: true,
+		OnBlock: func(ev traversal.BlockEvent) {
+			if ev.Duplicate {
+				switch ev.Direction {
+				case traversal.BlockIn:
+					dupsIn++
+				case traversal.BlockOut:
+					dupsOut++
+				}
+			}
+		},
+	}
+	carStream, _ := makeCarStream(t, ctx, []cid.Cid{fileWithDups.Root}, consumedBlocks(fileWithDupsBlocks), false, false, true, nil, false, false)
+	outLsys := cidlink.DefaultLinkSystem()
+	outLsys.SetWriteStorage(&memstore.Store{Bag: make(map[string][]byte)})
+	result, err := cfg.VerifyCar(ctx, carStream, outLsys)
+	req.NoError(err)
+	// all but the root, the first of the repeated leaves and the shorter final
+	// leaf are duplicates
+	req.Equal(int(result.BlocksIn)-3, dupsIn)
+	req.Equal(int(result.BlocksOut)-3, dupsOut)
+}
+
+func TestVerifyCarOversizedBlock(t *testing.T) {
+	ctx := context.Background()
+
+	data := []byte("not very big")
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}.Sum(data)
+	require.NoError(t, err)
+
+	// a CAR whose only section claims to hold 4 GiB of block data but is
+	// actually truncated after the CID; if the length were trusted and
+	// allocated before being checked we'd see an allocation or EOF problem
+	// rather than a size error
+	var buf bytes.Buffer
+	w, err := storage.NewWritable(&buf, []cid.Cid{c}, car.WriteAsCarV1(true))
+	require.NoError(t, err)
+	require.NoError(t, w.Finalize())
+	buf.Write(varint.ToUvarint(uint64(c.ByteLen()) + 4<<30))
+	buf.Write(c.Bytes())
+	crafted := buf.Bytes()
+
+	for _, tc := range []struct {
+		name      string
+		cfg       traversal.Config
+		expectErr error
+	}{
+		{"default limit", traversal.Config{}, traversal.ErrBlockTooLarge},
+		{"explicit limit", traversal.Config{MaxBlockSize: 1 << 20}, traversal.ErrBlockTooLarge},
+		{"total bytes limit", traversal.Config{MaxBlockSize: 8 << 30, MaxTotalBytes: 1 << 20}, traversal.ErrTooManyBytes},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.Root = c
+			cfg.Selector = selectorparse.CommonSelector_ExploreAllRecursively
+			_, err := cfg.VerifyCar(ctx, bytes.NewReader(crafted), cidlink.DefaultLinkSystem())
+			require.ErrorIs(t, err, tc.expectErr)
+		})
+	}
+}
+
+func TestVerifyBlockStreamTimeouts(t *testing.T) {
+	ctx := context.Background()
+
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{
+		Bag: make(map[string][]byte),
+	}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	tbc := trustlesstestutil.SetupBlockChain(ctx, t, lsys, 1000, 10)
+	root := tbc.TipLink.(cidlink.Link).Cid
+
+	for _, tc := range []struct {
+		name      string
+		cfg       traversal.Config
+		delay     time.Duration
+		stallAt   int
+		expectErr error
+	}{
+		{
+			name:      "idle timeout",
+			cfg:       traversal.Config{IdleTimeout: 50 * time.Millisecond},
+			stallAt:   3,
+			expectErr: traversal.ErrIdleTimeout,
+		},
+		{
+			name:      "deadline",
+			cfg:       traversal.Config{MaxDuration: 50 * time.Millisecond},
+			stallAt:   3,
+			expectErr: traversal.ErrDeadline,
+		},
+		{
+			name:      "deadline with slow blocks",
+			cfg:       traversal.Config{MaxDuration: 50 * time.Millisecond, IdleTimeout: time.Second},
+			delay:     10 * time.Millisecond,
+			stallAt:   -1,
+			expectErr: traversal.ErrDeadline,
+		},
+		{
+			name:    "slow blocks within idle timeout",
+			cfg:     traversal.Config{IdleTimeout: time.Second},
+			delay:   5 * time.Millisecond,
+			stallAt: -1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			stall := make(chan struct{})
+			t.Cleanup(func() { close(stall) })
+
+			cfg := tc.cfg
+			cfg.Root = root
+			cfg.Selector = selectorparse.CommonSelector_ExploreAllRecursively
+			bs := &stallingBlockStream{blks: tbc.AllBlocks(), delay: tc.delay, stallAt: tc.stallAt, stall: stall}
+
+			lsys := cidlink.DefaultLinkSystem()
+			lsys.SetWriteStorage(&memstore.Store{Bag: make(map[string][]byte)})
+			start := time.Now()
+			result, err := cfg.VerifyBlockStream(ctx, bs, lsys)
+			req.Less(time.Since(start), time.Second)
+			if tc.expectErr != nil {
+				req.ErrorIs(err, tc.expectErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(uint64(len(tbc.AllBlocks())), result.BlocksIn)
+		})
+	}
+}
+
+func TestVerifyBlockStreamTiming(t *testing.T) {
+	ctx := context.Background()
+	req := require.New(t)
+
+	tbc := trustlesstestutil.SetupBlockChain(ctx, t, newStoreLinkSystem(), 1000, 5)
+	blks := tbc.AllBlocks()
+
+	// the clock only moves when the stream is read: each block arrives after
+	// its delay, and the end of the stream is found 5ms after the last block
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	delays := []time.Duration{100 * time.Millisecond, 10 * time.Millisecond, 30 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond}
+	var sent int
+	bs := traversal.BlockStreamFunc(func(ctx context.Context) (blocks.Block, error) {
+		if sent >= len(blks) {
+			clock.advance(5 * time.Millisecond)
+			return nil, io.EOF
+		}
+		clock.advance(delays[sent])
+		sent++
+		return blks[sent-1], nil
+	})
+
+	cfg := traversal.Config{
+		Root:     tbc.TipLink.(cidlink.Link).Cid,
+		Selector: selectorparse.CommonSelector_ExploreAllRecursively,
+		Clock:    clock,
+	}
+	outLsys := newOutputLinkSystem()
+	result, err := cfg.VerifyBlockStream(ctx, bs, outLsys)
+	req.NoError(err)
+	req.Equal(uint64(len(blks)), result.BlocksIn)
+	req.Equal(205*time.Millisecond, result.Duration)
+	req.Equal(100*time.Millisecond, result.TimeToFirstBlock)
+	req.Equal(100*time.Millisecond, result.TimeToRoot)
+	req.Equal(10*time.Millisecond, result.MinBlockInterval)
+	req.Equal(40*time.Millisecond, result.MaxBlockInterval)
+	req.Equal(25*time.Millisecond, result.MeanBlockInterval)
+	req.InDelta(float64(result.BytesIn)/0.205, result.Throughput(), 0.001)
+
+	// nothing received
+	clock = &manualClock{now: time.Unix(1000, 0)}
+	cfg.Clock = clock
+	result, err = cfg.VerifyBlockStream(ctx, traversal.BlockStreamFunc(func(ctx context.Context) (blocks.Block, error) {
+		clock.advance(time.Second)
+		return nil, io.EOF
+	}), outLsys)
+	req.ErrorIs(err, traversal.ErrMissingBlock)
+	req.Equal(traversal.TraversalResult{}, result)
+	req.Zero(result.Throughput())
+}
+
+// manualClock is a traversal.Clock that only moves when advanced.
+type manualClock struct {
+	now time.Time
+}
+
+func (mc *manualClock) Now() time.Time { return mc.now }
+
+func (mc *manualClock) advance(d time.Duration) { mc.now = mc.now.Add(d) }
+
+// stallingBlockStream is a BlockStream that emits blocks with an optional
+// delay and stalls, ignoring the context, once stallAt blocks have been sent.
+type stallingBlockStream struct {
+	blks    []blocks.Block
+	delay   time.Duration
+	stallAt int
+	stall   chan struct{}
+	sent    int
+}
+
+func (sbs *stallingBlockStream) Next(ctx context.Context) (blocks.Block, error) {
+	if sbs.sent == sbs.stallAt {
+		<-sbs.stall
+		return nil, io.EOF
+	}
+	time.Sleep(sbs.delay)
+	if sbs.sent >= len(sbs.blks) {
+		return nil, io.EOF
+	}
+	blk := sbs.blks[sbs.sent]
+	sbs.sent++
+	return blk, nil
+}
+
+// newStoreLinkSystem returns a LinkSystem that reads from and writes to a
+// fresh in-memory store, for building test DAGs.
+func newStoreLinkSystem() linking.LinkSystem {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{
+		Bag: make(map[string][]byte),
+	}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	return lsys
+}
+
+// newOutputLinkSystem returns a write-only LinkSystem to receive the verified
+// blocks.
+func newOutputLinkSystem() linking.LinkSystem {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetWriteStorage(&memstore.Store{Bag: make(map[string][]byte)})
+	return lsys
+}
+
+// sliceStream is a BlockStream that emits a slice of blocks in order, counting
+// the blocks read.
+type sliceStream struct {
+	blks []blocks.Block
+	read int
+}
+
+func sliceBlockStream(blks []blocks.Block) *sliceStream {
+	return &sliceStream{blks: blks}
+}
+
+func (ss *sliceStream) Next(ctx context.Context) (blocks.Block, error) {
+	if ss.read >= len(ss.blks) {
+		return nil, io.EOF
+	}
+	ss.read++
+	return ss.blks[ss.read-1], nil
+}
+
 func makeCarStream(
 	t *testing.T,
 	ctx context.Context,
@@ -1009,3 +1491,306 @@ func sizeOf(blocks []expectedBlock) uint64 {
 	}
 	return total
 }
+
+func TestVerifyDataModelPathing(t *testing.T) {
+	ctx := context.Background()
+	lsys := newStoreLinkSystem()
+
+	cborlp := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_256, MhLength: -1}}
+	rawlp := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}}
+	storeBlock := func(lp cidlink.LinkPrototype, n datamodel.Node) blocks.Block {
+		l, err := lsys.Store(linking.LinkContext{}, lp, n)
+		require.NoError(t, err)
+		byts, err := lsys.LoadRaw(linking.LinkContext{}, l)
+		require.NoError(t, err)
+		blk, err := blocks.NewBlockWithCid(byts, l.(cidlink.Link).Cid)
+		require.NoError(t, err)
+		return blk
+	}
+
+	// root: {"name": "root", "list": [&a, &b]}
+	// a: {"v": 1, "child": &c}
+	// b: raw bytes
+	// c: {"deep": {"leaf": true}}
+	c := storeBlock(cborlp, must(qp.BuildMap(basicnode.Prototype.Any, 1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "deep", qp.Map(1, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "leaf", qp.Bool(true))
+		}))
+	})))
+	a := storeBlock(cborlp, must(qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "v", qp.Int(1))
+		qp.MapEntry(ma, "child", qp.Link(cidlink.Link{Cid: c.Cid()}))
+	})))
+	b := storeBlock(rawlp, basicnode.NewBytes([]byte("bytes in b")))
+	root := storeBlock(cborlp, must(qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "name", qp.String("root"))
+		qp.MapEntry(ma, "list", qp.List(2, func(la datamodel.ListAssembler) {
+			qp.ListEntry(la, qp.Link(cidlink.Link{Cid: a.Cid()}))
+			qp.ListEntry(la, qp.Link(cidlink.Link{Cid: b.Cid()}))
+		}))
+	})))
+
+	for _, tc := range []struct {
+		name       string
+		path       string
+		scope      trustlessutils.DagScope
+		blocks     []blocks.Block
+		expectPath string
+		expectErr  error
+	}{
+		{name: "all", scope: trustlessutils.DagScopeAll, blocks: []blocks.Block{root, a, c, b}, expectPath: "name"},
+		{name: "block", scope: trustlessutils.DagScopeBlock, blocks: []blocks.Block{root}},
+		{name: "entity is block", scope: trustlessutils.DagScopeEntity, blocks: []blocks.Block{root}},
+		{name: "field within root", path: "name", scope: trustlessutils.DagScopeEntity, blocks: []blocks.Block{root}, expectPath: "name"},
+		{name: "list index crosses link", path: "list/1", scope: trustlessutils.DagScopeBlock, blocks: []blocks.Block{root, b}, expectPath: "list/1"},
+		{name: "list index entity", path: "list/0", scope: trustlessutils.DagScopeEntity, blocks: []blocks.Block{root, a}, expectPath: "list/0"},
+		{name: "list index all", path: "list/0", scope: trustlessutils.DagScopeAll, blocks: []blocks.Block{root, a, c}, expectPath: "list/0/child/deep/leaf"},
+		{name: "multiple links", path: "list/0/child/deep/leaf", scope: trustlessutils.DagScopeEntity, blocks: []blocks.Block{root, a, c}, expectPath: "list/0/child/deep/leaf"},
+		{name: "field within linked block", path: "list/0/v", scope: trustlessutils.DagScopeEntity, blocks: []blocks.Block{root, a}, expectPath: "list/0/v"},
+		{name: "missing path", path: "list/2", scope: trustlessutils.DagScopeAll, blocks: []blocks.Block{root}, expectPath: "list"},
+		{name: "extraneous", path: "list/1", scope: trustlessutils.DagScopeEntity, blocks: []blocks.Block{root, b, a}, expectErr: traversal.ErrExtraneousBlock},
+		{name: "all requires linked blocks", path: "list/0", scope: trustlessutils.DagScopeAll, blocks: []blocks.Block{root, a}, expectErr: traversal.ErrMissingBlock},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			request := trustlessutils.Request{Path: tc.path, Scope: tc.scope, Pathing: trustlessutils.PathingDataModel}
+			cfg := traversal.Config{Root: root.Cid(), Selector: request.Selector()}
+
+			result, err := cfg.VerifyBlockStream(ctx, sliceBlockStream(tc.blocks), newOutputLinkSystem())
+			if tc.expectErr != nil {
+				req.ErrorIs(err, tc.expectErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(uint64(len(tc.blocks)), result.BlocksIn)
+			req.Equal(tc.expectPath, result.LastPath.String())
+		})
+	}
+}
+
+func TestVerifyCodecs(t *testing.T) {
+	ctx := context.Background()
+
+	lsys := newStoreLinkSystem()
+	tbc := trustlesstestutil.SetupBlockChain(ctx, t, lsys, 1000, 10)
+	chainRoot := tbc.TipLink.(cidlink.Link).Cid
+	chainBlocks := tbc.AllBlocks()
+
+	// a block using a codec that has no registered decoder, but happens to be
+	// encoded as dag-json
+	const privateCodec = 0x300001
+	privateByts, err := ipld.Encode(basicnode.NewString("private"), dagjson.Encode)
+	require.NoError(t, err)
+	privateMh, err := mh.Sum(privateByts, mh.SHA2_256, -1)
+	require.NoError(t, err)
+	privateBlk, err := blocks.NewBlockWithCid(privateByts, cid.NewCidV1(privateCodec, privateMh))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		root      cid.Cid
+		blocks    []blocks.Block
+		cfg       traversal.Config
+		expectErr error
+	}{
+		{name: "default", root: chainRoot, blocks: chainBlocks},
+		{name: "allowed", root: chainRoot, blocks: chainBlocks, cfg: traversal.Config{AllowedCodecs: []uint64{cid.Raw, cid.DagCBOR}}},
+		{name: "disallowed", root: chainRoot, blocks: chainBlocks, cfg: traversal.Config{AllowedCodecs: []uint64{cid.Raw, cid.DagJSON}}, expectErr: traversal.ErrDisallowedCodec},
+		{name: "explicit decoders", root: chainRoot, blocks: chainBlocks, cfg: traversal.Config{Decoders: map[uint64]codec.Decoder{cid.DagCBOR: dagcbor.Decode}}},
+		{name: "explicit decoders missing codec", root: chainRoot, blocks: chainBlocks, cfg: traversal.Config{Decoders: map[uint64]codec.Decoder{cid.DagJSON: dagjson.Decode}}, expectErr: traversal.ErrUnknownCodec},
+		{name: "unknown codec", root: privateBlk.Cid(), blocks: []blocks.Block{privateBlk}, expectErr: traversal.ErrUnknownCodec},
+		{name: "custom codec", root: privateBlk.Cid(), blocks: []blocks.Block{privateBlk}, cfg: traversal.Config{Decoders: map[uint64]codec.Decoder{privateCodec: dagjson.Decode}}},
+		{name: "custom codec disallowed", root: privateBlk.Cid(), blocks: []blocks.Block{privateBlk}, cfg: traversal.Config{Decoders: map[uint64]codec.Decoder{privateCodec: dagjson.Decode}, AllowedCodecs: []uint64{cid.DagCBOR}}, expectErr: traversal.ErrDisallowedCodec},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			cfg := tc.cfg
+			cfg.Root = tc.root
+			cfg.Selector = selectorparse.CommonSelector_ExploreAllRecursively
+
+			bs := sliceBlockStream(tc.blocks)
+			result, err := cfg.VerifyBlockStream(ctx, bs, newOutputLinkSystem())
+			if tc.expectErr != nil {
+				req.ErrorIs(err, tc.expectErr)
+				if errors.Is(err, traversal.ErrDisallowedCodec) {
+					req.Zero(bs.read, "disallowed codecs should be rejected before reading")
+				}
+				return
+			}
+			req.NoError(err)
+			req.Equal(uint64(len(tc.blocks)), result.BlocksIn)
+		})
+	}
+
+	t.Run("traverse", func(t *testing.T) {
+		cfg := traversal.Config{
+			Root:          chainRoot,
+			Selector:      selectorparse.CommonSelector_ExploreAllRecursively,
+			AllowedCodecs: []uint64{cid.Raw},
+		}
+		_, err := cfg.Traverse(ctx, lsys, nil)
+		require.ErrorIs(t, err, traversal.ErrDisallowedCodec)
+	})
+}
+
+func TestVerifyMultihashes(t *testing.T) {
+	ctx := context.Background()
+
+	data := []byte("some raw block data")
+	rawBlock := func(mhType uint64, mhLength int) blocks.Block {
+		c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mhType, MhLength: mhLength}.Sum(data)
+		require.NoError(t, err)
+		blk, err := blocks.NewBlockWithCid(data, c)
+		require.NoError(t, err)
+		return blk
+	}
+	sha1Blk := rawBlock(mh.SHA1, -1)
+	parent, err := qp.BuildMap(basicnode.Prototype.Any, 1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "child", qp.Link(cidlink.Link{Cid: sha1Blk.Cid()}))
+	})
+	require.NoError(t, err)
+	parentByts, err := ipld.Encode(parent, dagcbor.Encode)
+	require.NoError(t, err)
+	parentCid, err := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: mh.SHA2_256, MhLength: -1}.Sum(parentByts)
+	require.NoError(t, err)
+	parentBlk, err := blocks.NewBlockWithCid(parentByts, parentCid)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name           string
+		blocks         []blocks.Block
+		allowed        []uint64
+		expectErr      error
+		expectBlocksIn int
+	}{
+		{name: "sha2-256", blocks: []blocks.Block{rawBlock(mh.SHA2_256, -1)}},
+		{name: "blake3", blocks: []blocks.Block{rawBlock(mh.BLAKE3, -1)}},
+		{name: "sha2-512", blocks: []blocks.Block{rawBlock(mh.SHA2_512, -1)}, expectErr: traversal.ErrDisallowedHash},
+		{name: "sha2-512 allowed", blocks: []blocks.Block{rawBlock(mh.SHA2_512, -1)}, allowed: []uint64{mh.SHA2_512}},
+		{name: "empty allowed uses defaults", blocks: []blocks.Block{rawBlock(mh.SHA2_256, -1)}, allowed: []uint64{}},
+		{name: "sha2-256 not allowed", blocks: []blocks.Block{rawBlock(mh.SHA2_256, -1)}, allowed: []uint64{mh.SHA2_512}, expectErr: traversal.ErrDisallowedHash},
+		{name: "sha1", blocks: []blocks.Block{sha1Blk}, expectErr: traversal.ErrDisallowedHash},
+		{name: "truncated sha2-256", blocks: []blocks.Block{rawBlock(mh.SHA2_256, 20)}, expectErr: traversal.ErrDisallowedHash},
+		{name: "linked sha1", blocks: []blocks.Block{parentBlk, sha1Blk}, expectErr: traversal.ErrDisallowedHash, expectBlocksIn: 1},
+		{name: "linked sha1 allowed", blocks: []blocks.Block{parentBlk, sha1Blk}, allowed: []uint64{mh.SHA2_256, mh.SHA1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			cfg := traversal.Config{
+				Root:               tc.blocks[0].Cid(),
+				Selector:           selectorparse.CommonSelector_ExploreAllRecursively,
+				AllowedMultihashes: tc.allowed,
+			}
+
+			bs := sliceBlockStream(tc.blocks)
+			result, err := cfg.VerifyBlockStream(ctx, bs, newOutputLinkSystem())
+			if tc.expectErr != nil {
+				req.ErrorIs(err, tc.expectErr)
+				req.Equal(tc.expectBlocksIn, bs.read, "disallowed hashes should be rejected before reading")
+				return
+			}
+			req.NoError(err)
+			req.Equal(uint64(len(tc.blocks)), result.BlocksIn)
+		})
+	}
+
+	t.Run("traverse", func(t *testing.T) {
+		// serving data already held locally applies no hash policy by default
+		lsys := newStoreLinkSystem()
+		sha512Blk := rawBlock(mh.SHA2_512, -1)
+		for _, blk := range []blocks.Block{sha512Blk, sha1Blk, parentBlk} {
+			w, wc, err := lsys.StorageWriteOpener(linking.LinkContext{Ctx: ctx})
+			require.NoError(t, err)
+			_, err = w.Write(blk.RawData())
+			require.NoError(t, err)
+			require.NoError(t, wc(cidlink.Link{Cid: blk.Cid()}))
+		}
+
+		for _, tc := range []struct {
+			name      string
+			root      cid.Cid
+			cfg       traversal.Config
+			expectErr error
+		}{
+			{name: "sha2-512", root: sha512Blk.Cid()},
+			{name: "linked sha1", root: parentBlk.Cid()},
+			{name: "sha2-512 not allowed", root: sha512Blk.Cid(), cfg: traversal.Config{AllowedMultihashes: []uint64{mh.SHA2_256}}, expectErr: traversal.ErrDisallowedHash},
+			{name: "linked sha1 not allowed", root: parentBlk.Cid(), cfg: traversal.Config{AllowedMultihashes: traversal.DefaultAllowedMultihashes}, expectErr: traversal.ErrDisallowedHash},
+			{name: "identity options opt in to default hashes", root: sha512Blk.Cid(), cfg: traversal.Config{RejectIdentity: true}, expectErr: traversal.ErrDisallowedHash},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				cfg := tc.cfg
+				cfg.Root = tc.root
+				cfg.Selector = selectorparse.CommonSelector_ExploreAllRecursively
+				_, err := cfg.Traverse(ctx, lsys, nil)
+				if tc.expectErr != nil {
+					require.ErrorIs(t, err, tc.expectErr)
+					return
+				}
+				require.NoError(t, err)
+			})
+		}
+	})
+}
+
+func TestVerifyIdentityLimits(t *testing.T) {
+	ctx := context.Background()
+
+	lsys := newStoreLinkSystem()
+
+	allSelector := selectorparse.CommonSelector_ExploreAllRecursively
+	identityDag := trustlesstestutil.MakeDagWithIdentity(t, lsys)
+	maxIdentityDag := trustlesstestutil.MakeDagWithLargeIdentity(t, lsys, traversal.DefaultMaxIdentityDigestSize)
+	overIdentityDag := trustlesstestutil.MakeDagWithLargeIdentity(t, lsys, traversal.DefaultMaxIdentityDigestSize+1)
+	hugeIdentityDag := trustlesstestutil.MakeDagWithLargeIdentity(t, lsys, 1000)
+
+	identityLength := func(dag unixfs.DirEntry) int {
+		var length int
+		var find func(unixfs.DirEntry)
+		find = func(de unixfs.DirEntry) {
+			if dmh, err := mh.Decode(de.Root.Hash()); err == nil && dmh.Code == mh.IDENTITY {
+				length = dmh.Length
+			}
+			for _, child := range de.Children {
+				find(child)
+			}
+		}
+		find(dag)
+		return length
+	}
+	require.Equal(t, traversal.DefaultMaxIdentityDigestSize, identityLength(maxIdentityDag))
+	require.Equal(t, traversal.DefaultMaxIdentityDigestSize+1, identityLength(overIdentityDag))
+	require.Equal(t, 1000, identityLength(hugeIdentityDag))
+
+	for _, tc := range []struct {
+		name      string
+		dag       unixfs.DirEntry
+		cfg       traversal.Config
+		expectErr error
+	}{
+		{name: "identity", dag: identityDag},
+		{name: "maximum identity", dag: maxIdentityDag},
+		{name: "oversized identity", dag: overIdentityDag, expectErr: traversal.ErrIdentityTooLarge},
+		{name: "huge identity", dag: hugeIdentityDag, expectErr: traversal.ErrIdentityTooLarge},
+		{name: "huge identity allowed", dag: hugeIdentityDag, cfg: traversal.Config{MaxIdentityDigestSize: 1000}},
+		{name: "lower maximum", dag: identityDag, cfg: traversal.Config{MaxIdentityDigestSize: 10}, expectErr: traversal.ErrIdentityTooLarge},
+		{name: "rejected", dag: identityDag, cfg: traversal.Config{RejectIdentity: true}, expectErr: traversal.ErrDisallowedHash},
+		{name: "not in allowed multihashes", dag: identityDag, cfg: traversal.Config{AllowedMultihashes: []uint64{mh.SHA2_256}}, expectErr: traversal.ErrDisallowedHash},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			cfg := tc.cfg
+			cfg.Root = tc.dag.Root
+			cfg.Selector = allSelector
+
+			blks := testutil.ToBlocks(t, lsys, tc.dag.Root, allSelector)
+			result, err := cfg.VerifyBlockStream(ctx, sliceBlockStream(blks), newOutputLinkSystem())
+			if tc.expectErr != nil {
+				req.ErrorIs(err, tc.expectErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(uint64(len(blks)), result.BlocksIn)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package trustlessutils
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"net/url"
@@ -56,6 +57,43 @@ func (ds DagScope) TerminalSelectorSpec() builder.SelectorSpec {
 	return unixfsnode.ExploreAllRecursivelySelector // default to explore-all for zero-value and unknown DagScope
 }
 
+// Pathing describes how the Path of a Request is interpreted.
+type Pathing string
+
+const (
+	// PathingUnixFS interprets each segment of a Path as a UnixFS directory
+	// entry name (including within HAMT sharded directories), as defined by
+	// the IPFS Path Gateway specification. This is the default.
+	PathingUnixFS Pathing = "unixfs"
+
+	// PathingDataModel interprets each segment of a Path as a plain IPLD
+	// data model path segment: a map key, or an integer index into a list.
+	// Links are crossed transparently wherever they are encountered, so a path
+	// may address nodes within a block as well as across blocks. This is
+	// suitable for non-UnixFS DAGs, such as those using dag-cbor or dag-json.
+	//
+	// With data model pathing, DagScopeAll explores the entire DAG beneath the
+	// terminus of the path, while DagScopeEntity and DagScopeBlock are
+	// equivalent and only include the blocks required to reach the terminus,
+	// which is to say, the terminal entity of a non-UnixFS DAG is the block
+	// that contains it. Bytes is ignored.
+	//
+	// This is a non-standard extension to the Trustless Gateway protocol.
+	PathingDataModel Pathing = "datamodel"
+)
+
+// ParsePathing parses a string form of a Pathing into a Pathing.
+func ParsePathing(s string) (Pathing, error) {
+	switch s {
+	case "unixfs":
+		return PathingUnixFS, nil
+	case "datamodel":
+		return PathingDataModel, nil
+	default:
+		return PathingUnixFS, fmt.Errorf("invalid Pathing: %q", s)
+	}
+}
+
 // ByteRange is used to represent the "entity-bytes" parameter of the IPFS
 // Trustless Gateway protocol.
 type ByteRange struct {
@@ -127,6 +165,40 @@ type Request struct {
 	// Duplicates is a flag that indicates whether duplicate blocks should be
 	// stored into the LinkSystem where they occur in the traversal.
 	Duplicates bool
+
+	// Pathing describes how Path is interpreted. If not set, PathingUnixFS is
+	// used. PathingDataModel is a non-standard extension to the Trustless
+	// Gateway protocol, carried in the "pathing" query parameter, so it should
+	// only be used with providers known to support it.
+	Pathing Pathing
+
+	// CustomSelector is an optional, arbitrary IPLD selector to execute from the
+	// Root. It is a non-standard extension to the Trustless Gateway protocol,
+	// carried in the "selector" query parameter, so it should only be used with
+	// providers known to support it. When set, Path, Scope and Bytes must be
+	// left unset.
+	CustomSelector datamodel.Node
+}
+
+// CanonicalCid returns the canonical form of c, so that different
+// representations of the same DAG root are treated the same. A CIDv0 is
+// upgraded to the equivalent CIDv1 (dag-pb, with the same multihash), whose
+// String form is base32. A CIDv1 is returned unchanged, as a cid.Cid does not
+// retain the multibase it was parsed from.
+func CanonicalCid(c cid.Cid) cid.Cid {
+	if c.Defined() && c.Version() == 0 {
+		return cid.NewCidV1(c.Type(), c.Hash())
+	}
+	return c
+}
+
+// Canonical returns a copy of the Request with Root replaced by its canonical
+// form, see CanonicalCid. Using the canonical Request means that UrlPath,
+// Etag and IpfsRoots are the same regardless of whether the DAG was
+// requested by CIDv0 or CIDv1.
+func (r Request) Canonical() Request {
+	r.Root = CanonicalCid(r.Root)
+	return r
 }
 
 // Selector generates an IPLD selector for this Request.
@@ -136,7 +208,17 @@ type Request struct {
 // Trustless Gateway, UnixFS compatible selector:
 //
 //	Request{Path: path, Scope: scope, Bytes: byteRange}.Selector()
+//
+// If CustomSelector is set, it is returned as-is. If Pathing is
+// PathingDataModel, see the documentation of PathingDataModel for the
+// selector that is generated.
 func (r Request) Selector() datamodel.Node {
+	if r.CustomSelector != nil {
+		return r.CustomSelector
+	}
+	if r.Pathing == PathingDataModel {
+		return r.dataModelSelector()
+	}
 	// Turn the path / scope into a selector
 	terminal := r.Scope.TerminalSelectorSpec()
 	// TODO: from the spec (https://specs.ipfs.tech/http-gateways/trustless-gateway/):
@@ -168,26 +250,121 @@ func (r Request) Selector() datamodel.Node {
 	return unixfsnode.UnixFSPathSelectorBuilder(r.Path, terminal, false)
 }
 
+func (r Request) dataModelSelector() datamodel.Node {
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	ss := matcherSelector
+	if r.Scope == DagScopeAll || r.Scope == "" {
+		ss = unixfsnode.ExploreAllRecursivelySelector
+	}
+	segments := datamodel.ParsePath(r.Path)
+	for segments.Len() > 0 {
+		// wrap the selector in ExploreFields as we walk back up the path, an
+		// ExploreFields segment will also address integer list indices and links
+		// will be loaded as they are encountered
+		next := ss
+		ss = ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert(segments.Last().String(), next)
+		})
+		segments = segments.Pop()
+	}
+	return ss.Node()
+}
+
 // UrlPath returns a URL path and query string valid with the Trusted HTTP
 // Gateway spec by combining the Path and the Scope of this request.
 //
 // The returned value includes a URL escaped form of the originally requested
-// path.
+// path, followed by the query string produced by CanonicalQuery.
+//
+// If CustomSelector is set, it is encoded into the non-standard "selector"
+// query parameter, and an error is returned if Path, Scope or Bytes are also
+// set. An error wrapping ErrBadPathSegment is returned if Path fails CheckPath.
 func (r Request) UrlPath() (string, error) {
+	if r.CustomSelector != nil {
+		if r.Path != "" || (r.Scope != "" && r.Scope != DagScopeAll) || !r.Bytes.IsDefault() {
+			return "", errors.New("a custom selector cannot be combined with a path, dag-scope or entity-bytes")
+		}
+	}
+	if err := CheckPath(r.Path); err != nil {
+		return "", err
+	}
+	query, err := r.CanonicalQuery()
+	if err != nil {
+		return "", err
+	}
+	return PathEscape(r.Path) + "?" + query, nil
+}
+
+// CanonicalQuery returns the canonical query string, without a leading "?",
+// for the parameters of this request. Each parameter appears at most once and
+// in a fixed order: dag-scope, which is always present, followed by
+// entity-bytes where Bytes is not the default, pathing where Pathing is
+// PathingDataModel (in which case entity-bytes is omitted as it is ignored),
+// and selector where CustomSelector is set.
+//
+// This is the encoding used by UrlPath, so that requests which are equivalent
+// produce the same URL.
+func (r Request) CanonicalQuery() (string, error) {
+	var sel string
+	if r.CustomSelector != nil {
+		var err error
+		if sel, err = EncodeSelector(r.CustomSelector); err != nil {
+			return "", err
+		}
+	}
+	return r.canonicalQuery(sel), nil
+}
+
+func (r Request) canonicalQuery(sel string) string {
 	scope := r.Scope
 	if r.Scope == "" {
 		scope = DagScopeAll
 	}
-	byteRange := ""
-	if !r.Bytes.IsDefault() {
-		byteRange = "&entity-bytes=" + r.Bytes.String()
+	var sb strings.Builder
+	sb.WriteString("dag-scope=")
+	sb.WriteString(string(scope))
+	if r.Pathing == PathingDataModel {
+		sb.WriteString("&pathing=")
+		sb.WriteString(string(PathingDataModel))
+	} else if !r.Bytes.IsDefault() {
+		sb.WriteString("&entity-bytes=")
+		sb.WriteString(r.Bytes.String())
+	}
+	if sel != "" {
+		sb.WriteString("&selector=")
+		sb.WriteString(sel)
+	}
+	return sb.String()
+}
+
+// ErrBadPathSegment is returned by CheckPath and Request.UrlPath for a path
+// segment that cannot be reliably carried in a URL.
+var ErrBadPathSegment = errors.New("invalid path segment")
+
+// CheckPath checks that every segment of an IPLD path, as would be produced
+// by datamodel.ParsePath, is a valid UnixFS name that will survive a round-trip
+// through PathEscape and URL parsing. Empty segments are ignored, as they are
+// collapsed by datamodel.ParsePath. The segments "." and ".." are rejected as
+// they are subject to dot-segment removal by HTTP clients and servers. A
+// segment cannot contain a "/" as it is always treated as a separator.
+//
+// Any other byte sequence, including "%", "?", "#", control characters and
+// invalid UTF-8, is permitted and is percent-encoded by PathEscape.
+func CheckPath(path string) error {
+	p := datamodel.ParsePath(path)
+	for _, ps := range p.Segments() {
+		switch ps.String() {
+		case ".", "..":
+			return fmt.Errorf("%w: %q", ErrBadPathSegment, ps.String())
+		}
 	}
-	path := PathEscape(r.Path)
-	return fmt.Sprintf("%s?dag-scope=%s%s", path, scope, byteRange), nil
+	return nil
 }
 
 // PathEscape both cleans an IPLD path and URL escapes it so that it can be
-// used in a URL path.
+// used in a URL path. Each segment is escaped with url.PathEscape, so that
+// url.URL#Path, or url.PathUnescape of each segment, recovers the original
+// bytes; see CheckPath for the paths that can be round-tripped.
 func PathEscape(path string) string {
 	if path == "" {
 		return path
@@ -206,6 +383,11 @@ func PathEscape(path string) string {
 // Etag produces a weak Etag suitable for use as an Etag HTTP response header.
 // The order parameter should match the CAR order parameter from the ContentType.
 //
+// The Etag is a hash of the root, the path and each parameter that is not the
+// default. Bytes is ignored with PathingDataModel, as for CanonicalQuery. The
+// Etag of a Request that doesn't set Pathing or CustomSelector is unchanged
+// from earlier versions.
+//
 // A weak Etag is used because:
 //   - Different implementations may include different parameters in the hash
 //   - Streaming gateways cannot include resolved path segments (only root+path)
@@ -228,8 +410,8 @@ func (r Request) Etag(order string) string {
 		h.Write([]byte(string(r.Scope)))
 	}
 
-	// Byte range: only include if not default
-	if !r.Bytes.IsDefault() {
+	// Byte range: only include if not default, and where it applies
+	if r.Pathing != PathingDataModel && !r.Bytes.IsDefault() {
 		h.Write([]byte("\x00range="))
 		h.Write([]byte(strconv.FormatInt(r.Bytes.From, 10)))
 		if r.Bytes.To != nil {
@@ -238,6 +420,24 @@ func (r Request) Etag(order string) string {
 		}
 	}
 
+	// Pathing: only include if not default (unixfs)
+	if r.Pathing == PathingDataModel {
+		h.Write([]byte("\x00pathing="))
+		h.Write([]byte(string(r.Pathing)))
+	}
+
+	// Selector: only include if set
+	if r.CustomSelector != nil {
+		h.Write([]byte("\x00selector="))
+		if byts, err := selectorBytes(r.CustomSelector); err == nil {
+			h.Write(byts)
+		} else {
+			// UrlPath fails for such a request, but it must still not share an
+			// Etag with the same request without a selector
+			h.Write([]byte("\x00invalid"))
+		}
+	}
+
 	// Order: only include if not default (dfs)
 	if order != "" && order != "dfs" {
 		h.Write([]byte("\x00order="))
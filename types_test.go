@@ -1,6 +1,7 @@
 package trustlessutils_test
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -9,9 +10,11 @@ import (
 	"github.com/ipfs/go-unixfsnode"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
 	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/multiformats/go-multibase"
 	"github.com/stretchr/testify/require"
 )
 
@@ -48,6 +51,28 @@ func TestDagScopeSelector(t *testing.T) {
 	require.Equal(t, unixfsnode.ExploreAllRecursivelySelector, trustlessutils.DagScope("").TerminalSelectorSpec())
 }
 
+func TestParsePathing(t *testing.T) {
+	for _, tc := range []struct {
+		pathing string
+		err     string
+	}{
+		{pathing: "unixfs"},
+		{pathing: "datamodel"},
+		{pathing: "UNIXFS", err: "invalid Pathing: \"UNIXFS\""},
+		{pathing: "", err: "invalid Pathing: \"\""},
+	} {
+		t.Run(tc.pathing, func(t *testing.T) {
+			actual, err := trustlessutils.ParsePathing(tc.pathing)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.pathing, string(actual))
+		})
+	}
+}
+
 func TestParseByteRange(t *testing.T) {
 	for _, tc := range []struct {
 		input    string
@@ -89,6 +114,17 @@ func TestRequestSelector(t *testing.T) {
 	exploreAll := `{"R":{":>":{"a":{">":{"@":{}}}},"l":{"none":{}}}}` // CommonSelector_ExploreAllRecursively
 	matchPoint := `{".":{}}`
 
+	dataModelFields := func(target string, fields ...string) string {
+		var sb strings.Builder
+		for _, n := range fields {
+			// explore field (f) + specific field (f>), with field name
+			sb.WriteString(fmt.Sprintf(`{"f":{"f>":{"%s":`, n))
+		}
+		sb.WriteString(target)
+		sb.WriteString(strings.Repeat(`}}}`, len(fields)))
+		return sb.String()
+	}
+
 	jsonFields := func(target string, fields ...string) string {
 		var sb strings.Builder
 		for _, n := range fields {
@@ -165,6 +201,36 @@ func TestRequestSelector(t *testing.T) {
 			req:  trustlessutils.Request{Path: "foo/bar/baz", Scope: trustlessutils.DagScopeEntity, Bytes: &trustlessutils.ByteRange{From: -100, To: ptr(-200)}},
 			sel:  jsonFields(fmt.Sprintf(matchUnixfsEntitySliceJsonFmt, -100, -200), "foo", "bar", "baz"), // note 200 not transformed for negative
 		},
+		{
+			name: "datamodel",
+			req:  trustlessutils.Request{Pathing: trustlessutils.PathingDataModel},
+			sel:  exploreAll,
+		},
+		{
+			name: "datamodel entity",
+			req:  trustlessutils.Request{Scope: trustlessutils.DagScopeEntity, Pathing: trustlessutils.PathingDataModel},
+			sel:  matchPoint,
+		},
+		{
+			name: "datamodel path + all",
+			req:  trustlessutils.Request{Path: "foo/0/baz", Scope: trustlessutils.DagScopeAll, Pathing: trustlessutils.PathingDataModel},
+			sel:  dataModelFields(exploreAll, "foo", "0", "baz"),
+		},
+		{
+			name: "datamodel path + entity",
+			req:  trustlessutils.Request{Path: "foo/0/baz", Scope: trustlessutils.DagScopeEntity, Pathing: trustlessutils.PathingDataModel},
+			sel:  dataModelFields(matchPoint, "foo", "0", "baz"),
+		},
+		{
+			name: "datamodel path + block",
+			req:  trustlessutils.Request{Path: "foo/0/baz", Scope: trustlessutils.DagScopeBlock, Pathing: trustlessutils.PathingDataModel},
+			sel:  dataModelFields(matchPoint, "foo", "0", "baz"),
+		},
+		{
+			name: "datamodel path + byte range entity",
+			req:  trustlessutils.Request{Path: "foo", Scope: trustlessutils.DagScopeEntity, Bytes: &trustlessutils.ByteRange{From: 100, To: ptr(200)}, Pathing: trustlessutils.PathingDataModel},
+			sel:  dataModelFields(matchPoint, "foo"),
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			selNode := tc.req.Selector()
@@ -175,6 +241,22 @@ func TestRequestSelector(t *testing.T) {
 	}
 }
 
+func TestEtagUnchanged(t *testing.T) {
+	// Etags issued before the Pathing and CustomSelector options existed
+	for _, tc := range []struct {
+		request  trustlessutils.Request
+		expected string
+	}{
+		{trustlessutils.Request{Root: testCidV1}, "8ef3k14o2apgd"},
+		{trustlessutils.Request{Root: testCidV1, Path: "/"}, "321rov32gvn7"},
+		{trustlessutils.Request{Root: testCidV1, Path: "a//b/"}, "98u2r41sjpf2v"},
+		{trustlessutils.Request{Root: testCidV1, Scope: trustlessutils.DagScopeAll}, "1ngtnrn9hreen"},
+		{trustlessutils.Request{Root: testCidV1, Path: "/", Scope: trustlessutils.DagScopeAll}, "ffhqkmvg0tu9l"},
+	} {
+		require.Equal(t, `W/"`+testCidV1.String()+".car."+tc.expected+`"`, tc.request.Etag("dfs"), "%+v", tc.request)
+	}
+}
+
 func TestEtag(t *testing.T) {
 	// To generate independent fixtures using Node.js, `npm install xxhash` then
 	// in a REPL:
@@ -372,6 +454,14 @@ func TestUrlPath(t *testing.T) {
 			},
 			expectedUrlPath: "/%3F/%23/%3B/&/%20/%21?dag-scope=all",
 		},
+		{
+			name: "escaped percent, encoded slash and non-UTF-8",
+			request: trustlessutils.Request{
+				Root: testCidV1,
+				Path: "/100%/a%2Fb/\xff\xfe/\x00\n/ж/.../.x",
+			},
+			expectedUrlPath: "/100%25/a%252Fb/%FF%FE/%00%0A/%D0%B6/.../.x?dag-scope=all",
+		},
 		{
 			name: "entity",
 			request: trustlessutils.Request{
@@ -423,6 +513,17 @@ func TestUrlPath(t *testing.T) {
 			},
 			expectedUrlPath: "/some/path/to/thing?dag-scope=entity&entity-bytes=100:-200",
 		},
+		{
+			name: "datamodel pathing",
+			request: trustlessutils.Request{
+				Root:    testCidV1,
+				Path:    "/some/0/thing",
+				Scope:   trustlessutils.DagScopeEntity,
+				Bytes:   &trustlessutils.ByteRange{From: 100, To: ptr(200)},
+				Pathing: trustlessutils.PathingDataModel,
+			},
+			expectedUrlPath: "/some/0/thing?dag-scope=entity&pathing=datamodel",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -434,6 +535,104 @@ func TestUrlPath(t *testing.T) {
 	}
 }
 
+func TestCanonicalQuery(t *testing.T) {
+	sel := trustlessutils.Request{Path: "foo"}.Selector()
+	encSel, err := trustlessutils.EncodeSelector(sel)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		request  trustlessutils.Request
+		expected string
+	}{
+		{"default", trustlessutils.Request{}, "dag-scope=all"},
+		{"scope", trustlessutils.Request{Scope: trustlessutils.DagScopeBlock}, "dag-scope=block"},
+		{"default bytes", trustlessutils.Request{Bytes: &trustlessutils.ByteRange{}}, "dag-scope=all"},
+		{"bytes", trustlessutils.Request{Scope: trustlessutils.DagScopeEntity, Bytes: &trustlessutils.ByteRange{From: 1, To: ptr(-1)}}, "dag-scope=entity&entity-bytes=1:-1"},
+		{"unixfs pathing", trustlessutils.Request{Pathing: trustlessutils.PathingUnixFS}, "dag-scope=all"},
+		{"data model pathing", trustlessutils.Request{Pathing: trustlessutils.PathingDataModel}, "dag-scope=all&pathing=datamodel"},
+		{"data model pathing ignores bytes", trustlessutils.Request{Pathing: trustlessutils.PathingDataModel, Bytes: &trustlessutils.ByteRange{From: 1}}, "dag-scope=all&pathing=datamodel"},
+		{"selector", trustlessutils.Request{CustomSelector: sel}, "dag-scope=all&selector=" + encSel},
+		{"path and duplicates not included", trustlessutils.Request{Path: "a/b", Duplicates: true}, "dag-scope=all"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := tc.request.CanonicalQuery()
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, q)
+		})
+	}
+
+	// equivalent requests share an Etag, different requests do not
+	root := trustlessutils.Request{Root: testCidV1, Scope: trustlessutils.DagScopeAll}
+	require.Equal(t, root.Etag("dfs"), root.Etag(""))
+	require.Equal(t, root.Etag("dfs"), trustlessutils.Request{Root: testCidV1, Scope: trustlessutils.DagScopeAll, Bytes: &trustlessutils.ByteRange{}}.Etag("dfs"))
+	dm := trustlessutils.Request{Root: testCidV1, Path: "a", Pathing: trustlessutils.PathingDataModel}
+	dmBytes := dm
+	dmBytes.Bytes = &trustlessutils.ByteRange{From: 10}
+	require.Equal(t, dm.Etag("dfs"), dmBytes.Etag("dfs"))
+	require.NotEqual(t, root.Etag("dfs"), root.Etag("unk"))
+	require.NotEqual(t, trustlessutils.Request{Root: testCidV1, Path: "a?dag-scope=entity"}.Etag("dfs"), trustlessutils.Request{Root: testCidV1, Path: "a", Scope: trustlessutils.DagScopeEntity}.Etag("dfs"))
+	require.NotEqual(t, trustlessutils.Request{Root: testCidV1, CustomSelector: sel}.Etag("dfs"), root.Etag("dfs"))
+
+	// a selector that can't be encoded fails UrlPath and doesn't collide with
+	// the request without a selector
+	bad := trustlessutils.Request{Root: testCidV1, CustomSelector: unencodableNode{sel}}
+	_, err = bad.CanonicalQuery()
+	require.ErrorContains(t, err, "failed to encode selector")
+	_, err = bad.UrlPath()
+	require.ErrorContains(t, err, "failed to encode selector")
+	require.NotEqual(t, root.Etag("dfs"), bad.Etag("dfs"))
+}
+
+// unencodableNode is a map node that fails to iterate, and so fails to encode.
+type unencodableNode struct {
+	datamodel.Node
+}
+
+func (unencodableNode) MapIterator() datamodel.MapIterator {
+	return failingMapIterator{}
+}
+
+type failingMapIterator struct{}
+
+func (failingMapIterator) Next() (datamodel.Node, datamodel.Node, error) {
+	return nil, nil, errors.New("iteration failed")
+}
+
+func (failingMapIterator) Done() bool { return false }
+
+func TestCheckPath(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		valid bool
+	}{
+		{"", true},
+		{"/", true},
+		{"a/b/c", true},
+		{"//a//b//", true},
+		{"...", true},
+		{".a/a./..a", true},
+		{"%2F/%2E/?/#", true},
+		{"\xff\x00", true},
+		{".", false},
+		{"..", false},
+		{"a/./b", false},
+		{"a/../b", false},
+		{"a/b/..", false},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			err := trustlessutils.CheckPath(tc.path)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, trustlessutils.ErrBadPathSegment)
+				_, err := trustlessutils.Request{Root: testCidV1, Path: tc.path}.UrlPath()
+				require.ErrorIs(t, err, trustlessutils.ErrBadPathSegment)
+			}
+		})
+	}
+}
+
 func TestIpfsRoots(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -482,6 +681,26 @@ func TestIpfsRoots(t *testing.T) {
 	}
 }
 
+func TestCanonical(t *testing.T) {
+	upgraded := trustlessutils.CanonicalCid(testCidV0)
+	require.Equal(t, uint64(1), upgraded.Version())
+	require.Equal(t, testCidV0.Type(), upgraded.Type())
+	require.Equal(t, testCidV0.Hash(), upgraded.Hash())
+	require.Equal(t, "bafybeidk4glzwfg5iolgwasb5puavqvajlkiswihrxc276qsqydeqnlo6y", upgraded.String())
+
+	require.Equal(t, testCidV1, trustlessutils.CanonicalCid(testCidV1))
+	base36 := cid.MustParse(testCidV1.Encode(multibase.MustNewEncoder(multibase.Base36)))
+	require.Equal(t, testCidV1, trustlessutils.CanonicalCid(base36))
+	require.Equal(t, cid.Undef, trustlessutils.CanonicalCid(cid.Undef))
+
+	v0 := trustlessutils.Request{Root: testCidV0, Path: "foo", Scope: trustlessutils.DagScopeEntity}
+	v1 := trustlessutils.Request{Root: upgraded, Path: "foo", Scope: trustlessutils.DagScopeEntity}
+	require.NotEqual(t, v1.Etag("dfs"), v0.Etag("dfs"))
+	require.Equal(t, v1.Etag("dfs"), v0.Canonical().Etag("dfs"))
+	require.Equal(t, v1, v0.Canonical())
+	require.Equal(t, testCidV0, v0.Root, "original request is unchanged")
+}
+
 func ptr(i int64) *int64 {
 	return &i
 }